@@ -11,6 +11,7 @@ import (
 	"sync"
 
 	"github.com/slcjordan/autodemo"
+	"github.com/slcjordan/autodemo/har"
 	"github.com/slcjordan/autodemo/logger"
 )
 
@@ -54,17 +55,15 @@ func (w *Worker) StartProject(ctx context.Context, name string) error {
 	return nil
 }
 
-func (w *Worker) StopProject(ctx context.Context, desc string) error {
+func (w *Worker) StopProject(ctx context.Context, project autodemo.Project) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
 	w.enabled = false
 	go w.Reset()
-	req, err := w.saveProject(ctx, autodemo.Project{
-		Name:       w.project,
-		WorkingDir: "/projects",
-		Desc:       desc,
-	})
+	project.Name = w.project
+	project.WorkingDir = "/projects"
+	req, err := w.saveProject(ctx, project)
 	if err != nil {
 		logger.Errorf(ctx, "could not create save project request: %s", err)
 		return err
@@ -81,6 +80,40 @@ func (w *Worker) StopProject(ctx context.Context, desc string) error {
 	return nil
 }
 
+func (w *Worker) ImportProject(ctx context.Context, project autodemo.Project, archive *har.Archive) error {
+	if fileExists(ctx, "../../projects", project.Name) {
+		return fmt.Errorf("project already exists: %q", project.Name)
+	}
+	project.WorkingDir = "/projects"
+	var buff bytes.Buffer
+	enc := json.NewEncoder(&buff)
+	err := enc.Encode(struct {
+		Project autodemo.Project
+		Archive *har.Archive
+	}{
+		Project: project,
+		Archive: archive,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://%s/project/har", w.Addr), &buff)
+	if err != nil {
+		logger.Errorf(ctx, "could not create import project request: %s", err)
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		logger.Errorf(ctx, "could not import project: %s", err)
+		return err
+	}
+	if (resp.StatusCode / 100) != 2 {
+		logger.Errorf(ctx, "got bad status when importing project: %d %s", resp.StatusCode, resp.Status)
+		return fmt.Errorf("bad status from worker backend: %d", resp.StatusCode)
+	}
+	return nil
+}
+
 func (w *Worker) Notify(history autodemo.History) {
 	w.mu.RLock()
 	defer w.mu.RUnlock()
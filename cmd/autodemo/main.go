@@ -5,7 +5,9 @@ import (
 	"crypto/tls"
 	"net/http"
 
+	"github.com/slcjordan/autodemo"
 	"github.com/slcjordan/autodemo/client"
+	"github.com/slcjordan/autodemo/har"
 	"github.com/slcjordan/autodemo/logger"
 	"github.com/slcjordan/autodemo/pki"
 	"github.com/slcjordan/autodemo/proxy"
@@ -13,7 +15,8 @@ import (
 )
 
 var insecureTransport = &http.Transport{
-	TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+	ForceAttemptHTTP2: true,
 }
 
 /*
@@ -54,23 +57,42 @@ func main() {
 	workerClient := &client.Worker{
 		Addr: "localhost:8080",
 	}
+	archive := &har.Writer{
+		Dir:      "../../archives",
+		Recorder: workerClient,
+	}
+	redactRules, err := transport.LoadRedactRules("redact.json")
+	if err != nil {
+		panic(err)
+	}
+	redactor, err := transport.NewRedactor(redactRules...)
+	if err != nil {
+		panic(err)
+	}
 	insecureCurl := &transport.Curl{
 		Transport: insecureTransport,
 		Listener:  workerClient,
+		Archive:   archive,
+		Redactor:  redactor,
 		Insecure:  true,
+		Truncate:  transport.DefaultTruncation(),
 	}
 	secureCurl := &transport.Curl{
 		Transport: http.DefaultTransport,
 		Listener:  workerClient,
+		Archive:   archive,
+		Redactor:  redactor,
+		Truncate:  transport.DefaultTruncation(),
 	}
 	workerClient.Reset = func() {
 		insecureCurl.Reset()
 		secureCurl.Reset()
+		redactor.Reset()
 	}
-	manager := proxy.NewManager(secureCurl, insecureCurl, pkiProvider, workerClient)
+	manager := proxy.NewManager(secureCurl, insecureCurl, pkiProvider, workerClient, "proxies.json")
 
 	defer manager.Shutdown(ctx)
-	defer workerClient.StopProject(ctx, "Verify digest escrow signing works")
+	defer workerClient.StopProject(ctx, autodemo.Project{Desc: "Verify digest escrow signing works"})
 
 	http.ListenAndServe("0.0.0.0:11080", logger.Middleware(manager))
 }
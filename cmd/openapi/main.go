@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/slcjordan/autodemo/db"
+	"github.com/slcjordan/autodemo/openapi"
+)
+
+func main() {
+	dbFile := flag.String("db", "../../mytest/db.sqlite", "database the projects were recorded to")
+	projects := flag.String("projects", "", "comma separated names of the projects to read")
+	out := flag.String("out", "openapi.yaml", "document to write, merged with what it already has")
+	title := flag.String("title", "Recorded API", "title of a new document")
+	flag.Parse()
+
+	if *projects == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	ctx := context.Background()
+	conn, err := db.Open(*dbFile)
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+	var recordings []openapi.Recording
+	for _, project := range strings.Split(*projects, ",") {
+		project = strings.TrimSpace(project)
+		histories, err := conn.ListHistories(ctx, project)
+		if err != nil {
+			panic(err)
+		}
+		if len(histories) == 0 {
+			fmt.Fprintf(os.Stderr, "no histories recorded for project %q\n", project)
+			os.Exit(1)
+		}
+		recordings = append(recordings, openapi.Recording{Project: project, Histories: histories})
+	}
+	existing, err := os.ReadFile(*out)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		panic(err)
+	}
+	data, err := openapi.Synthesize(ctx, existing, *title, recordings...)
+	if err != nil {
+		panic(err)
+	}
+	err = os.WriteFile(*out, data, 0644)
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/slcjordan/autodemo/db"
+	"github.com/slcjordan/autodemo/replay"
+)
+
+func main() {
+	dbFile := flag.String("db", "../../mytest/db.sqlite", "database the project was recorded to")
+	projectsDir := flag.String("projects", "../../projects", "directory the report is written to")
+	project := flag.String("project", "", "name of the project to replay")
+	baseURL := flag.String("base", "", "base url to replay against instead of the recorded one")
+	ignoreFile := flag.String("ignore", "ignore.json", "ignore rules for fields that change between runs")
+	flag.Parse()
+
+	if *project == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	ctx := context.Background()
+	ignore, err := replay.LoadIgnoreRules(*ignoreFile)
+	if err != nil {
+		panic(err)
+	}
+	conn, err := db.Open(*dbFile)
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+	histories, err := conn.ListHistories(ctx, *project)
+	if err != nil {
+		panic(err)
+	}
+	if len(histories) == 0 {
+		fmt.Fprintf(os.Stderr, "no histories recorded for project %q\n", *project)
+		os.Exit(1)
+	}
+	report := replay.Run(ctx, *project, histories, replay.Options{
+		BaseURL: *baseURL,
+		Ignore:  ignore,
+	})
+	dir := filepath.Join(*projectsDir, *project)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		panic(err)
+	}
+	err = report.Write(dir)
+	if err != nil {
+		panic(err)
+	}
+	for _, step := range report.Steps {
+		status := "ok"
+		if !step.Passed() {
+			status = "FAIL"
+		}
+		fmt.Printf("%-4s %d %s %s\n", status, step.Index, step.Method, step.URL)
+		if step.Error != "" {
+			fmt.Printf("     %s\n", step.Error)
+		}
+		for _, diff := range step.Diffs {
+			fmt.Printf("     %s: %s != %s\n", diff.Path, diff.Expected, diff.Actual)
+		}
+	}
+	if !report.Passed() {
+		os.Exit(1)
+	}
+}
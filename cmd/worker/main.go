@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/slcjordan/autodemo/db"
+	"github.com/slcjordan/autodemo/transport"
 	"github.com/slcjordan/autodemo/video"
 )
 
@@ -24,5 +25,13 @@ func main() {
 	}
 	go w.Run(ctx)
 
-	http.ListenAndServe(":8080", video.NewAPI(conn))
+	redactRules, err := transport.LoadRedactRules("redact.json")
+	if err != nil {
+		panic(err)
+	}
+	err = transport.ValidateRedactRules(redactRules)
+	if err != nil {
+		panic(err)
+	}
+	http.ListenAndServe(":8080", video.NewAPI(conn, redactRules, transport.DefaultTruncation()))
 }
@@ -5,8 +5,10 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 
 	_ "embed"
 
@@ -89,6 +91,94 @@ func (c *Conn) DoNextHistoryJob(ctx context.Context, project autodemo.Project, f
 	return f(ctx, project, history)
 }
 
+// RewriteHistories passes the queued histories of a project through f in
+// recording order and saves what f returns. f may leave histories out, the
+// jobs left over are deleted. Nothing is rewritten once any history of the
+// project has been recorded.
+func (c *Conn) RewriteHistories(ctx context.Context, project string, f func([]autodemo.History) []autodemo.History) error {
+	queries := sqlc.New(c.db)
+	q := (queries).WithTx(c.tx)
+	works, err := q.ListWork(ctx, sqlc.ListWorkParams{
+		Domain:  "history",
+		Project: project,
+	})
+	if err != nil {
+		return err
+	}
+	histories := make([]autodemo.History, len(works))
+	for i, work := range works {
+		if work.Status == "done" {
+			return nil
+		}
+		err = json.Unmarshal(work.Data, &histories[i])
+		if err != nil {
+			return err
+		}
+	}
+	sort.Sort(byIndex{works, histories})
+	rewritten := f(histories)
+	if len(rewritten) > len(works) {
+		return fmt.Errorf("rewrite returned %d histories for %d jobs", len(rewritten), len(works))
+	}
+	for _, work := range works[len(rewritten):] {
+		err = q.DeleteWork(ctx, work.ID)
+		if err != nil {
+			return err
+		}
+	}
+	for i, history := range rewritten {
+		data, err := json.Marshal(history)
+		if err != nil {
+			return err
+		}
+		err = q.UpdateWorkData(ctx, sqlc.UpdateWorkDataParams{
+			Data: data,
+			ID:   works[i].ID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListHistories returns the histories of a project in recording order. It
+// reads within the transaction of the project job when there is one.
+func (c *Conn) ListHistories(ctx context.Context, project string) ([]autodemo.History, error) {
+	queries := sqlc.New(c.db)
+	if c.tx != nil {
+		queries = queries.WithTx(c.tx)
+	}
+	works, err := queries.ListWork(ctx, sqlc.ListWorkParams{
+		Domain:  "history",
+		Project: project,
+	})
+	if err != nil {
+		return nil, err
+	}
+	histories := make([]autodemo.History, len(works))
+	for i, work := range works {
+		err = json.Unmarshal(work.Data, &histories[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Sort(byIndex{works, histories})
+	return histories, nil
+}
+
+type byIndex struct {
+	works     []sqlc.ListWorkRow
+	histories []autodemo.History
+}
+
+func (b byIndex) Len() int           { return len(b.works) }
+func (b byIndex) Less(i, j int) bool { return b.histories[i].Index < b.histories[j].Index }
+func (b byIndex) Swap(i, j int) {
+	b.works[i], b.works[j] = b.works[j], b.works[i]
+	b.histories[i], b.histories[j] = b.histories[j], b.histories[i]
+}
+
 func (c *Conn) MaybeSaveProjectJob(ctx context.Context, project autodemo.Project) error {
 	queries := sqlc.New(c.db)
 	data, err := json.Marshal(project)
@@ -156,6 +246,7 @@ func (c *Conn) DoNextProjectJob(ctx context.Context, f func(context.Context, str
 		return err
 	}
 	c.tx = tx
+	defer func() { c.tx = nil }()
 	err = f(ctx, work.Status, project)
 	if err != nil {
 		os.WriteFile(filepath.Join(project.WorkingDir, project.Name, "error.txt"), []byte(err.Error()), 0644)
@@ -0,0 +1,5 @@
+-- DeleteWork removes queued work.
+-- name: DeleteWork :exec
+
+DELETE FROM work_queue
+WHERE id = @id;
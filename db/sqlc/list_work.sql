@@ -0,0 +1,7 @@
+-- ListWork lists the work of a project in the order it was created.
+-- name: ListWork :many
+
+SELECT id, status, data
+FROM work_queue
+WHERE domain=@domain AND project=@project
+ORDER BY id;
@@ -0,0 +1,6 @@
+-- UpdateWorkData replaces the data of queued work.
+-- name: UpdateWorkData :exec
+
+UPDATE work_queue
+SET data = @data, updated_at = CURRENT_TIMESTAMP
+WHERE id = @id;
@@ -0,0 +1,265 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/slcjordan/autodemo"
+	"github.com/slcjordan/autodemo/extract"
+	"github.com/slcjordan/autodemo/replay"
+)
+
+// Files are the exports written next to combined.md.
+const (
+	GoTestFile  = "export_test.go"
+	HurlFile    = "export.hurl"
+	PostmanFile = "postman_collection.json"
+)
+
+// maxAssertions keeps the exports readable for large responses.
+const maxAssertions = 8
+
+// assertion checks the JSON value at path. Path holds object keys as strings
+// and array indexes as ints.
+type assertion struct {
+	path  []any
+	value any
+}
+
+// capture reads an extracted variable from a response.
+type capture struct {
+	name string
+	path []any
+}
+
+type step struct {
+	autodemo.History
+	headers     map[string][]string
+	contentType string // media type of the response
+	assertions  []assertion
+	captures    []capture
+}
+
+func (s step) name() string {
+	u, err := url.Parse(s.Request.URL)
+	if err != nil {
+		return fmt.Sprintf("%03d %s %s", s.Index, s.Request.Method, s.Request.URL)
+	}
+	return fmt.Sprintf("%03d %s %s", s.Index, s.Request.Method, u.Path)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func pathStrings(p []any) []string {
+	result := make([]string, len(p))
+	for i, segment := range p {
+		result[i] = fmt.Sprint(segment)
+	}
+	return result
+}
+
+// assertions picks the scalar fields near the top of a JSON response that
+// are expected to be the same on every run. Values that were extracted into
+// variables change between runs and are captured instead.
+func assertions(body string, variables []string) []assertion {
+	var doc any
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.UseNumber()
+	if dec.Decode(&doc) != nil {
+		return nil
+	}
+	ignore := replay.DefaultIgnoreRules()
+	var result []assertion
+	var walk func(val any, p []any)
+	walk = func(val any, p []any) {
+		if len(result) >= maxAssertions || replay.Ignored(ignore, replay.IgnoreJSON, pathStrings(p)) {
+			return
+		}
+		switch v := val.(type) {
+		case map[string]any:
+			if len(p) >= 2 {
+				return
+			}
+			for _, key := range sortedKeys(v) {
+				walk(v[key], append(p[:len(p):len(p)], key))
+			}
+		case []any:
+			if len(p) >= 2 || len(v) == 0 {
+				return
+			}
+			walk(v[0], append(p[:len(p):len(p)], 0))
+		case string:
+			if strings.HasPrefix(v, "$") || containsAny(v, variables) {
+				return
+			}
+			result = append(result, assertion{path: p, value: v})
+		case json.Number:
+			if containsAny(v.String(), variables) {
+				return
+			}
+			result = append(result, assertion{path: p, value: v})
+		default:
+			result = append(result, assertion{path: p, value: v})
+		}
+	}
+	walk(doc, nil)
+	return result
+}
+
+func containsAny(s string, values []string) bool {
+	for _, value := range values {
+		if strings.Contains(s, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func header(h map[string][]string, key string) string {
+	for k, values := range h {
+		if strings.EqualFold(k, key) && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+func steps(histories []autodemo.History) []step {
+	var variables []string
+	for _, h := range histories {
+		for _, v := range h.Extract {
+			variables = append(variables, v.Value)
+		}
+	}
+	var result []step
+	for _, h := range histories {
+		if h.Request.WebSocket || h.Failure != "" {
+			// none of the exports can hold a WebSocket session or a
+			// request that got no response.
+			continue
+		}
+		s := step{
+			History: h,
+			headers: replay.Headers(h.Request),
+		}
+		s.contentType, _, _ = mime.ParseMediaType(header(h.Response.Header, "Content-Type"))
+		if len(h.Chunks) == 0 {
+			s.assertions = assertions(h.Response.Body, variables)
+		}
+		for _, v := range h.Extract {
+			p, err := extract.Segments(v.Path)
+			if err != nil {
+				continue
+			}
+			s.captures = append(s.captures, capture{name: v.Name, path: p})
+		}
+		result = append(result, s)
+	}
+	return result
+}
+
+// environment lists the variables that are not captured from a response,
+// such as redacted secrets, so they have to be given when the export runs.
+func environment(histories []autodemo.History) []string {
+	captured := make(map[string]bool)
+	env := make(map[string]bool)
+	for _, h := range histories {
+		for _, name := range h.Request.Vars {
+			if !captured[name] {
+				env[name] = true
+			}
+		}
+		for _, v := range h.Extract {
+			captured[v.Name] = true
+		}
+	}
+	return sortedKeys(env)
+}
+
+// testName turns a project name into a Go identifier.
+func testName(project string) string {
+	var result strings.Builder
+	upper := true
+	for _, r := range project {
+		switch {
+		case unicode.IsLetter(r) || (unicode.IsDigit(r) && result.Len() > 0):
+			if upper {
+				r = unicode.ToUpper(r)
+			}
+			result.WriteRune(r)
+			upper = false
+		default:
+			upper = true
+		}
+	}
+	if result.Len() == 0 {
+		return "Project"
+	}
+	return result.String()
+}
+
+// jqPath writes p the way jq and JavaScript read it.
+func jqPath(p []any) string {
+	var result strings.Builder
+	for _, segment := range p {
+		switch s := segment.(type) {
+		case int:
+			fmt.Fprintf(&result, "[%d]", s)
+		case string:
+			if isIdentifier(s) {
+				result.WriteString("." + s)
+			} else {
+				result.WriteString("[" + strconv.Quote(s) + "]")
+			}
+		}
+	}
+	return result.String()
+}
+
+func isIdentifier(key string) bool {
+	for i, r := range key {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return key != ""
+}
+
+// Write saves the Go test, Hurl file and Postman collection of a project to
+// dir where the artifacts of the histories are also written.
+func Write(dir string, project string, histories []autodemo.History) error {
+	exports := []struct {
+		filename string
+		export   func(string, []autodemo.History) ([]byte, error)
+	}{
+		{GoTestFile, GoTest},
+		{HurlFile, Hurl},
+		{PostmanFile, Postman},
+	}
+	for _, e := range exports {
+		data, err := e.export(project, histories)
+		if err != nil {
+			return fmt.Errorf("could not export %s: %w", e.filename, err)
+		}
+		err = os.WriteFile(filepath.Join(dir, e.filename), data, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
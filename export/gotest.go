@@ -0,0 +1,280 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"strconv"
+	"strings"
+
+	"github.com/slcjordan/autodemo"
+	"github.com/slcjordan/autodemo/shell"
+)
+
+const goTestHelpers = `
+type response struct {
+	*http.Response
+	body []byte
+}
+
+type formPart struct {
+	name        string
+	value       string
+	file        string
+	contentType string
+}
+
+// client keeps one cookie jar per jar of the recording.
+func client(jars map[string]http.CookieJar, jar string, insecure bool) *http.Client {
+	c := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	if insecure {
+		c.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+	if jar != "" {
+		if jars[jar] == nil {
+			jars[jar], _ = cookiejar.New(nil)
+		}
+		c.Jar = jars[jar]
+	}
+	return c
+}
+
+func readFile(t *testing.T, filename string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func multipartBody(t *testing.T, parts ...formPart) (io.Reader, string) {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for _, part := range parts {
+		if part.file == "" {
+			err := form.WriteField(part.name, part.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf("form-data; name=%q; filename=%q", part.name, part.file))
+		if part.contentType != "" {
+			header.Set("Content-Type", part.contentType)
+		}
+		w, err := form.CreatePart(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = w.Write(readFile(t, part.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := form.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &body, form.FormDataContentType()
+}
+
+func do(t *testing.T, c *http.Client, req *http.Request) response {
+	t.Helper()
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response{resp, body}
+}
+
+func expectStatus(t *testing.T, resp response, status int) {
+	t.Helper()
+	if resp.StatusCode != status {
+		t.Errorf("%s %s: got status %d, want %d", resp.Request.Method, resp.Request.URL, resp.StatusCode, status)
+	}
+}
+
+func expectHeader(t *testing.T, resp response, key string, want string) {
+	t.Helper()
+	if got := resp.Header.Get(key); !strings.Contains(got, want) {
+		t.Errorf("%s %s: got %s %q, want %q", resp.Request.Method, resp.Request.URL, key, got, want)
+	}
+}
+
+func lookup(t *testing.T, resp response, path ...any) any {
+	t.Helper()
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(resp.body))
+	dec.UseNumber()
+	err := dec.Decode(&doc)
+	if err != nil {
+		t.Fatalf("%s %s: %s", resp.Request.Method, resp.Request.URL, err)
+	}
+	for _, segment := range path {
+		switch v := doc.(type) {
+		case map[string]any:
+			key, _ := segment.(string)
+			doc = v[key]
+		case []any:
+			index, ok := segment.(int)
+			if !ok || index >= len(v) {
+				return nil
+			}
+			doc = v[index]
+		default:
+			return nil
+		}
+	}
+	return doc
+}
+
+func expectJSON(t *testing.T, resp response, want string, path ...any) {
+	t.Helper()
+	got, _ := json.Marshal(lookup(t, resp, path...))
+	if string(got) != want {
+		t.Errorf("%s %s: got %v = %s, want %s", resp.Request.Method, resp.Request.URL, path, got, want)
+	}
+}
+
+func capture(t *testing.T, resp response, path ...any) string {
+	t.Helper()
+	switch v := lookup(t, resp, path...).(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case nil:
+		t.Fatalf("%s %s: nothing to capture at %v", resp.Request.Method, resp.Request.URL, path)
+	}
+	return ""
+}
+`
+
+// goExpr writes a string of a request as a Go expression that reads its
+// $NAME references with v.
+func goExpr(vars []string, s string) string {
+	var parts []string
+	for _, segment := range shell.SplitVars(s, vars) {
+		if segment.Var {
+			parts = append(parts, fmt.Sprintf("v(%q)", strings.Trim(segment.Text, "${}")))
+			continue
+		}
+		parts = append(parts, strconv.Quote(segment.Text))
+	}
+	if len(parts) == 0 {
+		return `""`
+	}
+	return strings.Join(parts, " + ")
+}
+
+func goPath(p []any) string {
+	var result []string
+	for _, segment := range p {
+		switch s := segment.(type) {
+		case int:
+			result = append(result, strconv.Itoa(s))
+		case string:
+			result = append(result, strconv.Quote(s))
+		}
+	}
+	return strings.Join(result, ", ")
+}
+
+func goArgs(args ...string) string {
+	var result []string
+	for _, arg := range args {
+		if arg != "" {
+			result = append(result, arg)
+		}
+	}
+	return strings.Join(result, ", ")
+}
+
+// GoTest writes the histories as a Go test that uses net/http, run with go
+// test -tags autodemo. Variables that are not captured from a response are
+// read from the environment.
+func GoTest(project string, histories []autodemo.History) ([]byte, error) {
+	var out strings.Builder
+	fmt.Fprintf(&out, "// Code generated by autodemo from project %q. DO NOT EDIT.\n\n", project)
+	// the tag keeps go test ./... of a checkout from calling the recorded
+	// servers.
+	fmt.Fprintf(&out, "//go:build autodemo\n\n")
+	fmt.Fprintf(&out, "package export\n\n")
+	fmt.Fprintf(&out, "import (\n")
+	for _, pkg := range []string{"bytes", "crypto/tls", "encoding/json", "fmt", "io", "mime/multipart", "net/http", "net/http/cookiejar", "net/textproto", "os", "strings", "testing"} {
+		fmt.Fprintf(&out, "\t%q\n", pkg)
+	}
+	fmt.Fprintf(&out, ")\n\n")
+	if env := environment(histories); len(env) > 0 {
+		fmt.Fprintf(&out, "// Set %s in the environment to run this test.\n", strings.Join(env, ", "))
+	}
+	fmt.Fprintf(&out, "func Test%s(t *testing.T) {\n", testName(project))
+	fmt.Fprintf(&out, "vars := make(map[string]string)\n")
+	fmt.Fprintf(&out, "v := func(name string) string {\nif val, ok := vars[name]; ok {\nreturn val\n}\nreturn os.Getenv(name)\n}\n")
+	fmt.Fprintf(&out, "_ = v\n")
+	fmt.Fprintf(&out, "jars := make(map[string]http.CookieJar)\n")
+	for _, s := range steps(histories) {
+		vars := s.Request.Vars
+		fmt.Fprintf(&out, "\n// %s\n{\n", s.name())
+		contentType := ""
+		switch {
+		case len(s.Request.Form) > 0:
+			fmt.Fprintf(&out, "body, contentType := multipartBody(t,\n")
+			for _, part := range s.Request.Form {
+				if part.File == "" {
+					fmt.Fprintf(&out, "formPart{name: %q, value: %s},\n", part.Name, goExpr(vars, part.Value))
+					continue
+				}
+				fmt.Fprintf(&out, "formPart{name: %q, file: %q, contentType: %q},\n", part.Name, part.File, part.ContentType)
+			}
+			fmt.Fprintf(&out, ")\n")
+			contentType = "contentType"
+		case s.Request.BodyFile != "":
+			fmt.Fprintf(&out, "body := bytes.NewReader(readFile(t, %q))\n", s.Request.BodyFile)
+		case s.Request.Body != "":
+			fmt.Fprintf(&out, "body := strings.NewReader(%s)\n", goExpr(vars, s.Request.Body))
+		default:
+			fmt.Fprintf(&out, "var body io.Reader\n")
+		}
+		fmt.Fprintf(&out, "req, err := http.NewRequest(%q, %s, body)\n", s.Request.Method, goExpr(vars, s.Request.URL))
+		fmt.Fprintf(&out, "if err != nil {\nt.Fatal(err)\n}\n")
+		for _, key := range sortedKeys(s.headers) {
+			for _, val := range s.headers[key] {
+				fmt.Fprintf(&out, "req.Header.Add(%q, %s)\n", key, goExpr(vars, val))
+			}
+		}
+		if contentType != "" {
+			fmt.Fprintf(&out, "req.Header.Set(\"Content-Type\", %s)\n", contentType)
+		}
+		fmt.Fprintf(&out, "resp := do(t, client(jars, %q, %t), req)\n", s.Request.CookieJar, s.Request.Insecure)
+		if s.Response.Status != 0 {
+			fmt.Fprintf(&out, "expectStatus(t, resp, %d)\n", s.Response.Status)
+		}
+		if s.contentType != "" {
+			fmt.Fprintf(&out, "expectHeader(t, resp, \"Content-Type\", %q)\n", s.contentType)
+		}
+		for _, a := range s.assertions {
+			want, _ := json.Marshal(a.value)
+			fmt.Fprintf(&out, "expectJSON(t, resp, %s)\n", goArgs(strconv.Quote(string(want)), goPath(a.path)))
+		}
+		for _, c := range s.captures {
+			fmt.Fprintf(&out, "vars[%q] = capture(%s)\n", c.name, goArgs("t", "resp", goPath(c.path)))
+		}
+		fmt.Fprintf(&out, "}\n")
+	}
+	fmt.Fprintf(&out, "}\n")
+	out.WriteString(goTestHelpers)
+	return format.Source([]byte(out.String()))
+}
@@ -0,0 +1,134 @@
+package export
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/slcjordan/autodemo"
+	"github.com/slcjordan/autodemo/shell"
+)
+
+// hurlTemplate writes the $NAME references of a request as {{NAME}}.
+func hurlTemplate(vars []string, s string) string {
+	var result strings.Builder
+	for _, segment := range shell.SplitVars(s, vars) {
+		if segment.Var {
+			result.WriteString("{{" + strings.Trim(segment.Text, "${}") + "}}")
+			continue
+		}
+		result.WriteString(segment.Text)
+	}
+	return result.String()
+}
+
+func hurlString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`).Replace(s) + `"`
+}
+
+func hurlValue(val any) string {
+	switch v := val.(type) {
+	case string:
+		return hurlString(v)
+	case nil:
+		return "null"
+	}
+	return fmt.Sprint(val)
+}
+
+func hurlJSONPath(p []any) string {
+	var result strings.Builder
+	result.WriteString("$")
+	for _, segment := range p {
+		switch s := segment.(type) {
+		case int:
+			fmt.Fprintf(&result, "[%d]", s)
+		case string:
+			if isIdentifier(s) {
+				result.WriteString("." + s)
+			} else {
+				result.WriteString("['" + strings.ReplaceAll(s, "'", `\'`) + "']")
+			}
+		}
+	}
+	return result.String()
+}
+
+// Hurl writes the histories as a Hurl file. Variables that are not captured
+// from a response are passed with --variable NAME=value. Hurl keeps a single
+// cookie store per file so the cookie jars of the recording are shared.
+func Hurl(project string, histories []autodemo.History) ([]byte, error) {
+	var out strings.Builder
+	fmt.Fprintf(&out, "# %s\n", project)
+	if env := environment(histories); len(env) > 0 {
+		fmt.Fprintf(&out, "# run with: hurl --test")
+		for _, name := range env {
+			fmt.Fprintf(&out, " --variable %s=...", name)
+		}
+		fmt.Fprintf(&out, " %s\n", HurlFile)
+	}
+	for _, s := range steps(histories) {
+		vars := s.Request.Vars
+		fmt.Fprintf(&out, "\n# %s\n", s.name())
+		fmt.Fprintf(&out, "%s %s\n", s.Request.Method, hurlTemplate(vars, s.Request.URL))
+		for _, key := range sortedKeys(s.headers) {
+			for _, val := range s.headers[key] {
+				fmt.Fprintf(&out, "%s: %s\n", key, hurlTemplate(vars, val))
+			}
+		}
+		if s.Request.Insecure || s.Request.UnixSocket != "" || s.Poll != nil {
+			fmt.Fprintf(&out, "[Options]\n")
+		}
+		if s.Request.Insecure {
+			fmt.Fprintf(&out, "insecure: true\n")
+		}
+		if s.Request.UnixSocket != "" {
+			fmt.Fprintf(&out, "unix-socket: %s\n", s.Request.UnixSocket)
+		}
+		if s.Poll != nil {
+			// the asserts only hold once the poll reached the last response.
+			fmt.Fprintf(&out, "retry: %d\nretry-interval: %d\n", 2*s.Poll.Count, max(s.Poll.Interval, time.Second).Milliseconds())
+		}
+		switch {
+		case len(s.Request.Form) > 0:
+			fmt.Fprintf(&out, "[MultipartFormData]\n")
+			for _, part := range s.Request.Form {
+				if part.File == "" {
+					fmt.Fprintf(&out, "%s: %s\n", part.Name, hurlTemplate(vars, part.Value))
+					continue
+				}
+				fmt.Fprintf(&out, "%s: file,%s;", part.Name, part.File)
+				if part.ContentType != "" {
+					fmt.Fprintf(&out, " %s", part.ContentType)
+				}
+				fmt.Fprintf(&out, "\n")
+			}
+		case s.Request.BodyFile != "":
+			fmt.Fprintf(&out, "file,%s;\n", s.Request.BodyFile)
+		case s.Request.Body != "":
+			fmt.Fprintf(&out, "```\n%s\n```\n", hurlTemplate(vars, strings.TrimSuffix(s.Request.Body, "\n")))
+		}
+
+		if s.Response.Status == 0 {
+			fmt.Fprintf(&out, "HTTP *\n")
+		} else {
+			fmt.Fprintf(&out, "HTTP %d\n", s.Response.Status)
+		}
+		if len(s.captures) > 0 {
+			fmt.Fprintf(&out, "[Captures]\n")
+			for _, c := range s.captures {
+				fmt.Fprintf(&out, "%s: jsonpath %s\n", c.name, hurlString(hurlJSONPath(c.path)))
+			}
+		}
+		if s.contentType != "" || len(s.assertions) > 0 {
+			fmt.Fprintf(&out, "[Asserts]\n")
+		}
+		if s.contentType != "" {
+			fmt.Fprintf(&out, "header \"Content-Type\" contains %s\n", hurlString(s.contentType))
+		}
+		for _, a := range s.assertions {
+			fmt.Fprintf(&out, "jsonpath %s == %s\n", hurlString(hurlJSONPath(a.path)), hurlValue(a.value))
+		}
+	}
+	return []byte(out.String()), nil
+}
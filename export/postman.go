@@ -0,0 +1,191 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/slcjordan/autodemo"
+	"github.com/slcjordan/autodemo/shell"
+)
+
+const postmanSchema = "https://schema.getpostman.com/json/collection/v2.1.0/collection.json"
+
+type postmanCollection struct {
+	Info     postmanInfo       `json:"info"`
+	Item     []postmanItem     `json:"item"`
+	Variable []postmanVariable `json:"variable,omitempty"`
+}
+
+type postmanInfo struct {
+	Name   string `json:"name"`
+	Schema string `json:"schema"`
+}
+
+type postmanVariable struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type postmanItem struct {
+	Name                    string          `json:"name"`
+	Request                 postmanRequest  `json:"request"`
+	Event                   []postmanEvent  `json:"event,omitempty"`
+	ProtocolProfileBehavior map[string]bool `json:"protocolProfileBehavior,omitempty"`
+}
+
+type postmanRequest struct {
+	Method string          `json:"method"`
+	Header []postmanHeader `json:"header"`
+	URL    string          `json:"url"`
+	Body   *postmanBody    `json:"body,omitempty"`
+}
+
+type postmanHeader struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type postmanBody struct {
+	Mode     string            `json:"mode"`
+	Raw      string            `json:"raw,omitempty"`
+	FormData []postmanFormData `json:"formdata,omitempty"`
+	File     *postmanFile      `json:"file,omitempty"`
+}
+
+type postmanFormData struct {
+	Key         string `json:"key"`
+	Value       string `json:"value,omitempty"`
+	Src         string `json:"src,omitempty"`
+	Type        string `json:"type"`
+	ContentType string `json:"contentType,omitempty"`
+}
+
+type postmanFile struct {
+	Src string `json:"src"`
+}
+
+type postmanEvent struct {
+	Listen string        `json:"listen"`
+	Script postmanScript `json:"script"`
+}
+
+type postmanScript struct {
+	Type string   `json:"type"`
+	Exec []string `json:"exec"`
+}
+
+// postmanTemplate writes the $NAME references of a request as {{NAME}}.
+func postmanTemplate(vars []string, s string) string {
+	var result strings.Builder
+	for _, segment := range shell.SplitVars(s, vars) {
+		if segment.Var {
+			result.WriteString("{{" + strings.Trim(segment.Text, "${}") + "}}")
+			continue
+		}
+		result.WriteString(segment.Text)
+	}
+	return result.String()
+}
+
+func jsString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+func postmanTests(s step) []string {
+	var lines []string
+	if s.Response.Status != 0 {
+		lines = append(lines,
+			fmt.Sprintf("pm.test(%s, function () {", jsString(fmt.Sprintf("status is %d", s.Response.Status))),
+			fmt.Sprintf("    pm.response.to.have.status(%d);", s.Response.Status),
+			"});",
+		)
+	}
+	if s.contentType != "" {
+		lines = append(lines,
+			fmt.Sprintf("pm.test(%s, function () {", jsString("content type is "+s.contentType)),
+			fmt.Sprintf("    pm.expect(pm.response.headers.get(\"Content-Type\")).to.include(%s);", jsString(s.contentType)),
+			"});",
+		)
+	}
+	if len(s.assertions) == 0 && len(s.captures) == 0 {
+		return lines
+	}
+	lines = append(lines, "var json = pm.response.json();")
+	for _, a := range s.assertions {
+		want, _ := json.Marshal(a.value)
+		lines = append(lines,
+			fmt.Sprintf("pm.test(%s, function () {", jsString(strings.TrimPrefix(jqPath(a.path), "."))),
+			fmt.Sprintf("    pm.expect(json%s).to.eql(%s);", jqPath(a.path), want),
+			"});",
+		)
+	}
+	for _, c := range s.captures {
+		lines = append(lines, fmt.Sprintf("pm.collectionVariables.set(%s, json%s);", jsString(c.name), jqPath(c.path)))
+	}
+	return lines
+}
+
+// Postman writes the histories as a Postman v2.1 collection. Variables that
+// are not captured from a response are collection variables to fill in.
+func Postman(project string, histories []autodemo.History) ([]byte, error) {
+	collection := postmanCollection{
+		Info: postmanInfo{
+			Name:   project,
+			Schema: postmanSchema,
+		},
+	}
+	for _, name := range environment(histories) {
+		collection.Variable = append(collection.Variable, postmanVariable{Key: name})
+	}
+	for _, s := range steps(histories) {
+		vars := s.Request.Vars
+		item := postmanItem{
+			Name: s.name(),
+			Request: postmanRequest{
+				Method: s.Request.Method,
+				Header: []postmanHeader{},
+				URL:    postmanTemplate(vars, s.Request.URL),
+			},
+		}
+		for _, key := range sortedKeys(s.headers) {
+			for _, val := range s.headers[key] {
+				item.Request.Header = append(item.Request.Header, postmanHeader{
+					Key:   key,
+					Value: postmanTemplate(vars, val),
+				})
+			}
+		}
+		switch {
+		case len(s.Request.Form) > 0:
+			body := postmanBody{Mode: "formdata"}
+			for _, part := range s.Request.Form {
+				data := postmanFormData{Key: part.Name, Type: "text", ContentType: part.ContentType}
+				if part.File == "" {
+					data.Value = postmanTemplate(vars, part.Value)
+				} else {
+					data.Type = "file"
+					data.Src = part.File
+				}
+				body.FormData = append(body.FormData, data)
+			}
+			item.Request.Body = &body
+		case s.Request.BodyFile != "":
+			item.Request.Body = &postmanBody{Mode: "file", File: &postmanFile{Src: s.Request.BodyFile}}
+		case s.Request.Body != "":
+			item.Request.Body = &postmanBody{Mode: "raw", Raw: postmanTemplate(vars, s.Request.Body)}
+		}
+		if s.Request.Insecure {
+			item.ProtocolProfileBehavior = map[string]bool{"strictSSL": false}
+		}
+		if tests := postmanTests(s); len(tests) > 0 {
+			item.Event = []postmanEvent{{
+				Listen: "test",
+				Script: postmanScript{Type: "text/javascript", Exec: tests},
+			}}
+		}
+		collection.Item = append(collection.Item, item)
+	}
+	return json.MarshalIndent(collection, "", "  ")
+}
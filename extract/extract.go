@@ -0,0 +1,382 @@
+package extract
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/slcjordan/autodemo"
+)
+
+// values shorter than these are too likely to show up by accident.
+const (
+	minStringLen = 4
+	minNumberLen = 2
+)
+
+type candidate struct {
+	value string
+	step  int
+	key   string
+	path  string // jq filter
+	owner string // key of the object holding the value
+}
+
+func isIDKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, suffix := range []string{"id", "uuid", "token", "key", "secret", "code", "cursor"} {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// looksLikeID is true for generated strings such as uuids and hashes.
+func looksLikeID(s string) bool {
+	var letters, digits int
+	for _, r := range s {
+		switch {
+		case unicode.IsLetter(r):
+			letters++
+		case unicode.IsDigit(r):
+			digits++
+		case r == '-' || r == '_':
+		default:
+			return false
+		}
+	}
+	return len(s) >= 8 && letters > 0 && digits > 0
+}
+
+func isIdentifier(key string) bool {
+	for i, r := range key {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return key != ""
+}
+
+func walk(val any, path string, key string, owner string, f func(path, key, owner, value string, number bool)) {
+	switch v := val.(type) {
+	case map[string]any:
+		for k, child := range v {
+			p := path + "." + k
+			if !isIdentifier(k) {
+				p = path + "[" + strconv.Quote(k) + "]"
+			}
+			walk(child, p, k, key, f)
+		}
+	case []any:
+		for i, child := range v {
+			walk(child, fmt.Sprintf("%s[%d]", path, i), key, owner, f)
+		}
+	case string:
+		f(path, key, owner, v, false)
+	case json.Number:
+		f(path, key, owner, v.String(), true)
+	}
+}
+
+// Segments splits the jq filters written by Variables into object keys,
+// written as .key or ["key"], and array indexes, written as [n]. Keys are
+// strings and indexes are ints.
+func Segments(filter string) ([]any, error) {
+	var segments []any
+	rest := strings.TrimPrefix(filter, ".")
+	if rest != "" && !strings.HasPrefix(rest, "[") {
+		rest = "." + rest
+	}
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, `["`):
+			end := 2
+			for end < len(rest) && rest[end] != '"' {
+				if rest[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end+1 >= len(rest) || rest[end+1] != ']' {
+				return nil, fmt.Errorf("bad filter %q", filter)
+			}
+			key, err := strconv.Unquote(rest[1 : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("bad filter %q: %w", filter, err)
+			}
+			segments = append(segments, key)
+			rest = rest[end+2:]
+		case strings.HasPrefix(rest, "["):
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("bad filter %q", filter)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("bad filter %q: %w", filter, err)
+			}
+			segments = append(segments, index)
+			rest = rest[end+1:]
+		case strings.HasPrefix(rest, "."):
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			segments = append(segments, rest[1:end+1])
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("bad filter %q", filter)
+		}
+	}
+	return segments, nil
+}
+
+func candidates(step int, body string) []candidate {
+	var doc any
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.UseNumber()
+	if dec.Decode(&doc) != nil {
+		return nil
+	}
+	var result []candidate
+	walk(doc, "", "", "", func(path, key, owner, value string, number bool) {
+		switch {
+		case strings.HasPrefix(value, "$"): // already redacted
+			return
+		case number && (!isIDKey(key) || len(value) < minNumberLen):
+			return
+		case !number && (len(value) < minStringLen || !(isIDKey(key) || looksLikeID(value))):
+			return
+		}
+		if path == "" {
+			path = "."
+		}
+		result = append(result, candidate{value: value, step: step, key: key, path: path, owner: owner})
+	})
+	return result
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || b == '-' || ('0' <= b && b <= '9') || ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z')
+}
+
+// replace substitutes whole occurrences of value in s.
+func replace(s string, value string, ref string) (string, bool) {
+	var result strings.Builder
+	found := false
+	for {
+		i := strings.Index(s, value)
+		if i < 0 {
+			break
+		}
+		end := i + len(value)
+		if (i > 0 && isWordByte(s[i-1])) || (end < len(s) && isWordByte(s[end])) {
+			result.WriteString(s[:end])
+			s = s[end:]
+			continue
+		}
+		result.WriteString(s[:i])
+		result.WriteString(ref)
+		s = s[end:]
+		found = true
+	}
+	result.WriteString(s)
+	return result.String(), found
+}
+
+func contains(req autodemo.Request, value string) bool {
+	_, found := rewrite(req, value, value)
+	return found
+}
+
+func rewriteHeader(header map[string][]string, value string, ref string) (map[string][]string, bool) {
+	if header == nil {
+		return nil, false
+	}
+	found := false
+	result := make(map[string][]string, len(header))
+	for key, values := range header {
+		for _, val := range values {
+			val, ok := replace(val, value, ref)
+			found = found || ok
+			result[key] = append(result[key], val)
+		}
+	}
+	return result, found
+}
+
+func rewrite(req autodemo.Request, value string, ref string) (autodemo.Request, bool) {
+	var found, ok bool
+	req.URL, ok = replace(req.URL, value, ref)
+	found = found || ok
+	req.Body, ok = replace(req.Body, value, ref)
+	found = found || ok
+	req.Header, ok = rewriteHeader(req.Header, value, ref)
+	found = found || ok
+	req.Hidden, ok = rewriteHeader(req.Hidden, value, ref)
+	found = found || ok
+	form := make([]autodemo.FormPart, len(req.Form))
+	for i, part := range req.Form {
+		form[i] = part
+		form[i].Value, ok = replace(part.Value, value, ref)
+		found = found || ok
+	}
+	req.Form = form
+	return req, found
+}
+
+func snakeCase(s string) string {
+	var result strings.Builder
+	var prev rune
+	for _, r := range s {
+		switch {
+		case unicode.IsUpper(r) && (unicode.IsLower(prev) || unicode.IsDigit(prev)):
+			result.WriteRune('_')
+			result.WriteRune(r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			result.WriteRune(unicode.ToUpper(r))
+		default:
+			r = '_'
+			if prev != '_' {
+				result.WriteRune(r)
+			}
+		}
+		prev = r
+	}
+	return strings.Trim(result.String(), "_")
+}
+
+func singular(s string) string {
+	switch {
+	case strings.HasSuffix(s, "ies"):
+		return strings.TrimSuffix(s, "ies") + "y"
+	case strings.HasSuffix(s, "ss"):
+		return s
+	}
+	return strings.TrimSuffix(s, "s")
+}
+
+// resource is the last path segment of a url that is not itself an id.
+func resource(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i := len(segments) - 1; i >= 0; i-- {
+		if segments[i] != "" && !strings.ContainsAny(segments[i], "0123456789") {
+			return segments[i]
+		}
+	}
+	return ""
+}
+
+// variableName turns the key of a value into a shell variable name. Keys
+// that do not say what they identify, like id, borrow the name of the
+// object holding them or of the resource that was requested.
+func variableName(c candidate, producer autodemo.Request) string {
+	name := snakeCase(c.key)
+	switch strings.ToLower(c.key) {
+	case "", "id", "uuid", "key", "token", "code":
+		prefix := c.owner
+		if prefix == "" || prefix == "data" || prefix == "result" {
+			prefix = resource(producer.URL)
+		}
+		if prefix != "" {
+			name = snakeCase(singular(prefix)) + "_" + name
+		}
+	}
+	if name == "" {
+		name = "VALUE"
+	}
+	if unicode.IsDigit(rune(name[0])) {
+		name = "V_" + name
+	}
+	return name
+}
+
+// Variables finds values that first appear in a JSON response and are then
+// sent back by later requests, such as the id of a created resource. The
+// later requests read the value from a shell variable and the step that
+// produced it saves its response and extracts the variable after it.
+func Variables(histories []autodemo.History) []autodemo.History {
+	result := make([]autodemo.History, len(histories))
+	copy(result, histories)
+
+	var all []candidate
+	seen := make(map[string]bool)
+	for i, h := range result {
+		for _, c := range candidates(i, h.Response.Body) {
+			if seen[c.value] {
+				continue
+			}
+			seen[c.value] = true
+			sent := false
+			for _, earlier := range result[:i+1] {
+				if contains(earlier.Request, c.value) {
+					sent = true
+					break
+				}
+			}
+			if !sent {
+				all = append(all, c)
+			}
+		}
+	}
+	// replace longer values first so that a value that contains another one
+	// keeps its own variable.
+	sort.SliceStable(all, func(i, j int) bool {
+		if len(all[i].value) != len(all[j].value) {
+			return len(all[i].value) > len(all[j].value)
+		}
+		if all[i].step != all[j].step {
+			return all[i].step < all[j].step
+		}
+		return all[i].path < all[j].path
+	})
+
+	names := make(map[string]int)
+	for _, h := range result {
+		for _, name := range h.Request.Vars {
+			names[name] = 1
+		}
+	}
+	for _, c := range all {
+		var name string
+		for j := c.step + 1; j < len(result); j++ {
+			if name == "" && !contains(result[j].Request, c.value) {
+				continue
+			}
+			if name == "" {
+				name = variableName(c, result[c.step].Request)
+				names[name]++
+				if n := names[name]; n > 1 {
+					name = fmt.Sprintf("%s_%d", name, n)
+				}
+				producer := &result[c.step]
+				if producer.SaveAs == "" {
+					producer.SaveAs = fmt.Sprintf("response-%03d.json", producer.Index)
+				}
+				producer.Extract = append(producer.Extract, autodemo.Variable{
+					Name:  name,
+					File:  producer.SaveAs,
+					Path:  c.path,
+					Value: c.value,
+				})
+			}
+			req, found := rewrite(result[j].Request, c.value, "$"+name)
+			if !found {
+				continue
+			}
+			req.Vars = append(append([]string(nil), req.Vars...), name)
+			sort.Strings(req.Vars)
+			result[j].Request = req
+		}
+	}
+	return result
+}
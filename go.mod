@@ -2,4 +2,22 @@ module github.com/slcjordan/autodemo
 
 go 1.23rc2
 
-require github.com/mattn/go-sqlite3 v1.14.24
+require (
+	github.com/andybalholm/brotli v1.1.0
+	github.com/getkin/kin-openapi v0.127.0
+	github.com/mattn/go-sqlite3 v1.14.24
+	golang.org/x/net v0.30.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/go-openapi/jsonpointer v0.21.0 // indirect
+	github.com/go-openapi/swag v0.23.0 // indirect
+	github.com/gorilla/mux v1.8.0 // indirect
+	github.com/invopop/yaml v0.3.1 // indirect
+	github.com/josharian/intern v1.0.0 // indirect
+	github.com/mailru/easyjson v0.7.7 // indirect
+	github.com/mohae/deepcopy v0.0.0-20170929034955-c48cc78d4826 // indirect
+	github.com/perimeterx/marshmallow v1.1.5 // indirect
+	golang.org/x/text v0.19.0 // indirect
+)
@@ -0,0 +1,382 @@
+package har
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/slcjordan/autodemo/logger"
+)
+
+// see http://www.softwareishard.com/blog/har-12-spec/
+const Version = "1.2"
+
+type Archive struct {
+	Log Log `json:"log"`
+}
+
+type Log struct {
+	Version string  `json:"version"`
+	Creator Creator `json:"creator"`
+	Entries []Entry `json:"entries"`
+}
+
+type Creator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type Entry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	Time            float64   `json:"time"`
+	Request         Request   `json:"request"`
+	Response        Response  `json:"response"`
+	Cache           struct{}  `json:"cache"`
+	Timings         Timings   `json:"timings"`
+	ServerIPAddress string    `json:"serverIPAddress,omitempty"`
+}
+
+type Request struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []Cookie    `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	QueryString []NameValue `json:"queryString"`
+	PostData    *PostData   `json:"postData,omitempty"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type Response struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []Cookie    `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	Content     Content     `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+	// Error is why there is no response, as browsers export it.
+	Error string `json:"_error,omitempty"`
+}
+
+type Cookie struct {
+	Name     string     `json:"name"`
+	Value    string     `json:"value"`
+	Path     string     `json:"path,omitempty"`
+	Domain   string     `json:"domain,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	HTTPOnly bool       `json:"httpOnly,omitempty"`
+	Secure   bool       `json:"secure,omitempty"`
+}
+
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type PostData struct {
+	MimeType string  `json:"mimeType"`
+	Text     string  `json:"text"`
+	Params   []Param `json:"params,omitempty"`
+}
+
+type Param struct {
+	Name        string `json:"name"`
+	Value       string `json:"value,omitempty"`
+	FileName    string `json:"fileName,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+}
+
+type Content struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// Timings are in milliseconds. -1 means the timing does not apply.
+type Timings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+func Millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func (t Timings) Total() float64 {
+	var total float64
+	for _, v := range []float64{t.Blocked, t.DNS, t.Connect, t.Send, t.Wait, t.Receive} {
+		if v > 0 {
+			total += v
+		}
+	}
+	return total
+}
+
+func headers(h http.Header) []NameValue {
+	result := []NameValue{}
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, val := range h[key] {
+			result = append(result, NameValue{Name: key, Value: val})
+		}
+	}
+	return result
+}
+
+func cookies(cs []*http.Cookie) []Cookie {
+	result := []Cookie{}
+	for _, c := range cs {
+		cookie := Cookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Domain:   c.Domain,
+			HTTPOnly: c.HttpOnly,
+			Secure:   c.Secure,
+		}
+		if !c.Expires.IsZero() {
+			expires := c.Expires
+			cookie.Expires = &expires
+		}
+		result = append(result, cookie)
+	}
+	return result
+}
+
+// IsText reports whether a body can be shown as text.
+func IsText(contentType string, body []byte) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "json"),
+		strings.HasSuffix(mediaType, "xml"),
+		mediaType == "application/x-www-form-urlencoded",
+		mediaType == "application/javascript":
+		return utf8.Valid(body)
+	}
+	return utf8.Valid(body) && !strings.ContainsRune(string(body), 0)
+}
+
+// NewEntry converts a finished round trip into a HAR entry. The bodies are
+// passed separately because the request and response bodies have usually
+// already been consumed by the time the entry is built. respBody is the
+// decoded body; callers set the response BodySize when it was sent encoded.
+func NewEntry(req *http.Request, reqBody []byte, resp *http.Response, respBody []byte, started time.Time, timings Timings) Entry {
+	entry := Entry{
+		StartedDateTime: started,
+		Time:            timings.Total(),
+		Timings:         timings,
+		Request: Request{
+			Method:      req.Method,
+			URL:         req.URL.String(),
+			HTTPVersion: req.Proto,
+			Cookies:     cookies(req.Cookies()),
+			Headers:     headers(req.Header),
+			QueryString: []NameValue{},
+			HeadersSize: -1,
+			BodySize:    len(reqBody),
+		},
+	}
+	if entry.Request.HTTPVersion == "" {
+		entry.Request.HTTPVersion = "HTTP/1.1"
+	}
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, val := range query[key] {
+			entry.Request.QueryString = append(entry.Request.QueryString, NameValue{Name: key, Value: val})
+		}
+	}
+	if len(reqBody) > 0 {
+		entry.Request.PostData = postData(req.Header.Get("Content-Type"), reqBody)
+	}
+	if resp == nil {
+		entry.Response = Response{
+			Cookies:     []Cookie{},
+			Headers:     []NameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		}
+		return entry
+	}
+	entry.Response = Response{
+		Status:      resp.StatusCode,
+		StatusText:  http.StatusText(resp.StatusCode),
+		HTTPVersion: resp.Proto,
+		Cookies:     cookies(resp.Cookies()),
+		Headers:     headers(resp.Header),
+		RedirectURL: resp.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    len(respBody),
+		Content: Content{
+			Size:     len(respBody),
+			MimeType: resp.Header.Get("Content-Type"),
+		},
+	}
+	if IsText(entry.Response.Content.MimeType, respBody) {
+		entry.Response.Content.Text = string(respBody)
+	} else {
+		entry.Response.Content.Text = base64.StdEncoding.EncodeToString(respBody)
+		entry.Response.Content.Encoding = "base64"
+	}
+	return entry
+}
+
+// postData keeps a text body as it is. A multipart body is listed by its
+// params with the values of the text fields, and any other body is left out
+// because HAR has no encoding for request bodies.
+func postData(contentType string, body []byte) *PostData {
+	data := &PostData{MimeType: contentType}
+	if IsText(contentType, body) {
+		data.Text = string(body)
+		return data
+	}
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+	if mediaType != "multipart/form-data" {
+		return data
+	}
+	reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return data
+		}
+		value, err := io.ReadAll(part)
+		if err != nil {
+			return data
+		}
+		param := Param{
+			Name:        part.FormName(),
+			FileName:    part.FileName(),
+			ContentType: part.Header.Get("Content-Type"),
+		}
+		if param.FileName == "" && IsText(param.ContentType, value) {
+			param.Value = string(value)
+		}
+		data.Params = append(data.Params, param)
+	}
+}
+
+func Read(r io.Reader) (*Archive, error) {
+	var archive Archive
+	dec := json.NewDecoder(r)
+	err := dec.Decode(&archive)
+	if err != nil {
+		return nil, err
+	}
+	return &archive, nil
+}
+
+type ProjectRecorder interface {
+	Recording() (string, bool)
+}
+
+// Writer keeps a HAR archive for the project that is currently being
+// recorded and rewrites <Dir>/<project>.har in the background as entries
+// come in, so that proxied requests do not wait for the disk.
+type Writer struct {
+	mu      sync.Mutex // guards project, entries, pending
+	project string
+	entries []Entry
+	pending bool // a save is on its way
+
+	saveMu sync.Mutex // keeps the saves in order
+
+	Dir      string
+	Recorder ProjectRecorder
+}
+
+func (w *Writer) NotifyEntry(entry Entry) {
+	name, recording := w.Recorder.Recording()
+	if !recording {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if name != w.project {
+		w.project = name
+		w.entries = nil
+	}
+	// entries mostly finish in the order they started, so this is usually
+	// an append.
+	i := sort.Search(len(w.entries), func(i int) bool {
+		return w.entries[i].StartedDateTime.After(entry.StartedDateTime)
+	})
+	w.entries = slices.Insert(w.entries, i, entry)
+	if !w.pending {
+		w.pending = true
+		go w.save()
+	}
+}
+
+// save writes the entries as they are when it gets its turn. The entries
+// that come in while it writes are left to the next save.
+func (w *Writer) save() {
+	w.saveMu.Lock()
+	defer w.saveMu.Unlock()
+
+	w.mu.Lock()
+	w.pending = false
+	project, entries := w.project, slices.Clone(w.entries)
+	w.mu.Unlock()
+
+	err := writeArchive(filepath.Join(w.Dir, project+".har"), entries)
+	if err != nil {
+		logger.Errorf(context.Background(), "could not save har archive for %q: %s", project, err)
+	}
+}
+
+func writeArchive(filename string, entries []Entry) error {
+	err := os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return err
+	}
+	archive := Archive{
+		Log: Log{
+			Version: Version,
+			Creator: Creator{Name: "autodemo", Version: "0.1"},
+			Entries: entries,
+		},
+	}
+	data, err := json.MarshalIndent(archive, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(filename+".tmp", data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
@@ -2,15 +2,144 @@ package autodemo
 
 import "time"
 
+// FormPart is one part of a multipart request body. File parts refer to
+// the artifact that holds their contents.
+type FormPart struct {
+	Name        string
+	Value       string
+	File        string
+	ContentType string
+}
+
+type Request struct {
+	Method string
+	URL    string
+	Header map[string][]string
+	// Hidden headers are not rendered but kept for replays and exports.
+	Hidden    map[string][]string
+	Body      string
+	BodyFile  string
+	Form      []FormPart
+	Insecure  bool
+	CookieJar string
+	// UnixSocket is the path of the socket the request was sent over. The
+	// host of the URL is then only a placeholder.
+	UnixSocket string
+	// H2C is set when HTTP/2 was spoken without TLS.
+	H2C bool
+	// Vars are the shell variables referenced as $NAME in the request.
+	Vars []string
+	// WebSocket is set when the request was upgraded to a WebSocket.
+	WebSocket bool
+}
+
+// Response keeps the decoded and redacted body so that later passes can
+// look inside it. The body is empty when it is binary.
+type Response struct {
+	Status int
+	Header map[string][]string
+	Body   string
+}
+
+// Variable is a value from a response that later requests reuse. It is read
+// from the response saved to File with the jq filter Path.
+type Variable struct {
+	Name  string
+	File  string
+	Path  string
+	Value string
+}
+
+// Artifact is a file that belongs to a recorded step such as an uploaded
+// file or a binary request body.
+type Artifact struct {
+	Name string
+	Data []byte
+}
+
+// Latency breaks down where the time of a round trip went. Phases that did
+// not happen, such as DNS on a reused connection, are zero.
+type Latency struct {
+	DNS       time.Duration
+	Connect   time.Duration
+	TLS       time.Duration
+	FirstByte time.Duration
+}
+
+// Chunk is a piece of a streamed response body and when it arrived,
+// relative to when the response headers did.
+type Chunk struct {
+	Offset time.Duration
+	Data   string
+}
+
+// Operation is the OpenAPI operation a step was matched to. Problems lists
+// where the request or response does not follow the operation's schemas.
+type Operation struct {
+	ID          string
+	Summary     string
+	Description string
+	Problems    []string
+}
+
+// Message is a WebSocket message and when it was sent or received,
+// relative to when the connection was upgraded.
+type Message struct {
+	Offset time.Duration
+	Sent   bool
+	Data   string
+}
+
 type History struct {
-	Index    int
-	Args     []string
-	Output   string
-	ExecTime time.Duration
+	Index     int
+	Args      []string
+	Output    string
+	Started   time.Time
+	ExecTime  time.Duration
+	Latency   Latency
+	Request   Request
+	Response  Response
+	Chunks    []Chunk
+	Messages  []Message
+	Artifacts []Artifact
+	// SaveAs is where the response body is saved for the Extract steps
+	// typed after the command.
+	SaveAs    string
+	Extract   []Variable
+	Operation Operation
+	// Failure is the curl error of a request that got no response.
+	Failure string
+	Poll    *Poll
+}
+
+// Poll is set on a step that stands for a run of requests that polled until
+// the response reached a terminal state. The step keeps the request and
+// response of the last poll and ExecTime covers the whole run.
+type Poll struct {
+	Count    int
+	Interval time.Duration
+	// Until is a jq filter that only holds for the last response. Without
+	// one the step waits for the response to change.
+	Until string
 }
 
+// Project settings for replaying ExecTime: the delay is multiplied by
+// LatencyScale (1 when unset) and capped at MaxLatency (no cap when unset).
+// Spinner animates the terminal while a slow call is replayed. OpenAPI is
+// a document the steps are labeled and validated with. DropFailures leaves
+// out the requests that got no response. HideHeaders are header globs the
+// project hides on top of the rules of the proxy it was recorded through.
 type Project struct {
-	Name       string
-	WorkingDir string
-	Desc       string
+	Name         string
+	WorkingDir   string
+	Desc         string
+	Renderer     string
+	Languages    []string
+	Shell        string
+	LatencyScale float64
+	MaxLatency   time.Duration
+	Spinner      bool
+	OpenAPI      string
+	DropFailures bool
+	HideHeaders  []string
 }
@@ -0,0 +1,167 @@
+package openapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+
+	"github.com/slcjordan/autodemo"
+)
+
+// Spec is an OpenAPI 3 document that recorded steps are matched against.
+type Spec struct {
+	doc    *openapi3.T
+	router routers.Router
+}
+
+var serverOrigin = regexp.MustCompile(`^[^/]*//[^/]*`)
+
+// relativeServers keeps only the base paths of the servers so that traffic
+// recorded against any host, such as a local proxy, still matches.
+func relativeServers(servers openapi3.Servers) {
+	for _, server := range servers {
+		server.URL = serverOrigin.ReplaceAllString(server.URL, "")
+		if server.URL == "" {
+			server.URL = "/"
+		}
+	}
+}
+
+// Parse reads a JSON or YAML document.
+func Parse(ctx context.Context, data []byte) (*Spec, error) {
+	loader := openapi3.NewLoader()
+	loader.Context = ctx
+	doc, err := loader.LoadFromData(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not load openapi document: %w", err)
+	}
+	err = doc.Validate(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("invalid openapi document: %w", err)
+	}
+	relativeServers(doc.Servers)
+	for _, item := range doc.Paths.Map() {
+		relativeServers(item.Servers)
+		for _, op := range item.Operations() {
+			if op.Servers != nil {
+				relativeServers(*op.Servers)
+			}
+		}
+	}
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, fmt.Errorf("could not route openapi document: %w", err)
+	}
+	return &Spec{doc: doc, router: router}, nil
+}
+
+func (s *Spec) Title() string {
+	if s == nil || s.doc.Info == nil {
+		return ""
+	}
+	return s.doc.Info.Title
+}
+
+func schemaError(err *openapi3.SchemaError) string {
+	pointer := strings.Join(err.JSONPointer(), "/")
+	if pointer == "" {
+		return err.Reason
+	}
+	return fmt.Sprintf("/%s: %s", pointer, err.Reason)
+}
+
+func problems(prefix string, err error) []string {
+	if err == nil {
+		return nil
+	}
+	var multi openapi3.MultiError
+	if !errors.As(err, &multi) {
+		return []string{prefix + err.Error()}
+	}
+	var result []string
+	for _, err := range multi {
+		result = append(result, problems(prefix, err)...)
+	}
+	return result
+}
+
+func request(ctx context.Context, h autodemo.History) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, h.Request.Method, h.Request.URL, strings.NewReader(h.Request.Body))
+	if err != nil {
+		return nil, err
+	}
+	for _, header := range []map[string][]string{h.Request.Hidden, h.Request.Header} {
+		for key, values := range header {
+			req.Header[http.CanonicalHeaderKey(key)] = values
+		}
+	}
+	return req, nil
+}
+
+// Label matches a step to an operation of the document and validates its
+// request and response. Bodies that were not kept as text, such as uploads
+// and binary responses, are not validated.
+func (s *Spec) Label(ctx context.Context, h autodemo.History) autodemo.History {
+	if s == nil {
+		return h
+	}
+	h.Operation = autodemo.Operation{}
+	req, err := request(ctx, h)
+	if err != nil {
+		h.Operation.Problems = []string{err.Error()}
+		return h
+	}
+	route, params, err := s.router.FindRoute(req)
+	if err != nil {
+		h.Operation.Problems = []string{fmt.Sprintf("no operation for %s %s", req.Method, req.URL.Path)}
+		return h
+	}
+	h.Operation.ID = route.Operation.OperationID
+	h.Operation.Summary = route.Operation.Summary
+	h.Operation.Description = route.Operation.Description
+
+	options := &openapi3filter.Options{
+		MultiError:         true,
+		AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+		ExcludeRequestBody: len(h.Request.Form) > 0 || h.Request.BodyFile != "",
+		// the recorded headers and bodies are already trimmed, the missing
+		// parts are not problems of the API.
+		ExcludeResponseBody:   h.Response.Body == "" || len(h.Chunks) > 0,
+		IncludeResponseStatus: true,
+	}
+	options.WithCustomSchemaErrorFunc(schemaError)
+	input := &openapi3filter.RequestValidationInput{
+		Request:    req,
+		PathParams: params,
+		Route:      route,
+		Options:    options,
+	}
+	err = openapi3filter.ValidateRequest(ctx, input)
+	h.Operation.Problems = append(h.Operation.Problems, problems("request: ", err)...)
+	if h.Response.Status == 0 {
+		return h
+	}
+	header := make(http.Header)
+	for key, values := range h.Response.Header {
+		header[http.CanonicalHeaderKey(key)] = values
+	}
+	err = openapi3filter.ValidateResponse(ctx, &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 h.Response.Status,
+		Header:                 header,
+		Body:                   io.NopCloser(bytes.NewReader([]byte(h.Response.Body))),
+		Options:                options,
+	})
+	h.Operation.Problems = append(h.Operation.Problems, problems("response: ", err)...)
+	return h
+}
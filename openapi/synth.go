@@ -0,0 +1,596 @@
+package openapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"gopkg.in/yaml.v3"
+
+	"github.com/slcjordan/autodemo"
+)
+
+// Recording is the stored histories of one project.
+type Recording struct {
+	Project   string
+	Histories []autodemo.History
+}
+
+var (
+	uuidSegment = regexp.MustCompile(`^[0-9a-fA-F]{8}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{12}$`)
+	hexSegment  = regexp.MustCompile(`^[0-9a-fA-F]{16,}$`)
+	varSegment  = regexp.MustCompile(`^\$\{?([A-Za-z_][A-Za-z0-9_]*)\}?$`)
+)
+
+// isParam guesses which path segments are values rather than names, such as
+// numbers, uuids, generated ids and the variables of a recording.
+func isParam(segment string) bool {
+	if varSegment.MatchString(segment) || uuidSegment.MatchString(segment) || hexSegment.MatchString(segment) {
+		return true
+	}
+	if _, err := strconv.ParseFloat(segment, 64); err == nil {
+		return true
+	}
+	var letters, digits int
+	for _, r := range segment {
+		switch {
+		case unicode.IsLetter(r):
+			letters++
+		case unicode.IsDigit(r):
+			digits++
+		}
+	}
+	return len(segment) >= 8 && letters > 0 && digits > 0
+}
+
+func singular(s string) string {
+	switch {
+	case strings.HasSuffix(s, "ies"):
+		return strings.TrimSuffix(s, "ies") + "y"
+	case strings.HasSuffix(s, "ss"):
+		return s
+	}
+	return strings.TrimSuffix(s, "s")
+}
+
+// camelCase turns names like ORDER_ID and order-items into orderId and
+// orderItems.
+func camelCase(s string) string {
+	var result strings.Builder
+	upper := false
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = result.Len() > 0
+			continue
+		}
+		if upper {
+			result.WriteRune(unicode.ToUpper(r))
+		} else {
+			result.WriteRune(unicode.ToLower(r))
+		}
+		upper = false
+	}
+	return result.String()
+}
+
+func title(s string) string {
+	s = camelCase(s)
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+func templateName(segment string) (string, bool) {
+	if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+		return segment[1 : len(segment)-1], true
+	}
+	return "", false
+}
+
+// template is a path of the document and the values a recorded path has in
+// its parameters.
+type template struct {
+	path   string
+	params map[string]string
+}
+
+// matchTemplate fits a recorded path into one of the document.
+func matchTemplate(paths *openapi3.Paths, segments []string) (template, bool) {
+	for _, path := range paths.InMatchingOrder() {
+		candidate := strings.Split(strings.Trim(path, "/"), "/")
+		if len(candidate) != len(segments) {
+			continue
+		}
+		params := make(map[string]string)
+		matched := true
+		for i, segment := range candidate {
+			if name, ok := templateName(segment); ok {
+				params[name] = segments[i]
+				continue
+			}
+			if segment != segments[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return template{path: path, params: params}, true
+		}
+	}
+	return template{}, false
+}
+
+func newTemplate(segments []string) template {
+	t := template{params: make(map[string]string)}
+	var parts []string
+	previous := ""
+	for _, segment := range segments {
+		if !isParam(segment) {
+			parts = append(parts, segment)
+			previous = segment
+			continue
+		}
+		name := "id"
+		if m := varSegment.FindStringSubmatch(segment); m != nil {
+			name = camelCase(m[1])
+		} else if previous != "" {
+			name = camelCase(singular(previous)) + "Id"
+		}
+		for n := 2; t.params[name] != ""; n++ {
+			name = fmt.Sprintf("%s%d", strings.TrimRight(name, "0123456789"), n)
+		}
+		t.params[name] = segment
+		parts = append(parts, "{"+name+"}")
+	}
+	t.path = "/" + strings.Join(parts, "/")
+	return t
+}
+
+func operationID(method string, path string) string {
+	var resource string
+	endsWithParam := false
+	for _, segment := range strings.Split(strings.Trim(path, "/"), "/") {
+		if _, ok := templateName(segment); ok {
+			endsWithParam = true
+			continue
+		}
+		if segment != "" {
+			resource = segment
+			endsWithParam = false
+		}
+	}
+	if resource == "" {
+		resource = "root"
+	}
+	switch method {
+	case "GET":
+		if endsWithParam {
+			return "get" + title(singular(resource))
+		}
+		return "list" + title(resource)
+	case "POST":
+		return "create" + title(singular(resource))
+	case "PUT", "PATCH":
+		return "update" + title(singular(resource))
+	case "DELETE":
+		return "delete" + title(singular(resource))
+	}
+	return strings.ToLower(method) + title(resource)
+}
+
+func decode(body string) (any, bool) {
+	var doc any
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.UseNumber()
+	if dec.Decode(&doc) != nil {
+		return nil, false
+	}
+	return doc, true
+}
+
+// plain turns decoded numbers into numbers so that examples are written as
+// numbers rather than strings.
+func plain(val any) any {
+	switch v := val.(type) {
+	case map[string]any:
+		result := make(map[string]any, len(v))
+		for key, child := range v {
+			result[key] = plain(child)
+		}
+		return result
+	case []any:
+		result := make([]any, len(v))
+		for i, child := range v {
+			result[i] = plain(child)
+		}
+		return result
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return val
+}
+
+func scalarSchema(val string) *openapi3.Schema {
+	if _, err := strconv.ParseInt(val, 10, 64); err == nil {
+		return openapi3.NewIntegerSchema()
+	}
+	if _, err := strconv.ParseFloat(val, 64); err == nil {
+		return openapi3.NewFloat64Schema()
+	}
+	if val == "true" || val == "false" {
+		return openapi3.NewBoolSchema()
+	}
+	return openapi3.NewStringSchema()
+}
+
+func schemaOf(val any) *openapi3.Schema {
+	switch v := val.(type) {
+	case map[string]any:
+		schema := openapi3.NewObjectSchema()
+		for key, child := range v {
+			schema.Properties[key] = openapi3.NewSchemaRef("", schemaOf(child))
+			schema.Required = append(schema.Required, key)
+		}
+		sort.Strings(schema.Required)
+		return schema
+	case []any:
+		schema := openapi3.NewArraySchema()
+		var items *openapi3.Schema
+		for _, child := range v {
+			items = mergeSchema(items, schemaOf(child))
+		}
+		if items == nil {
+			items = openapi3.NewSchema()
+		}
+		schema.Items = openapi3.NewSchemaRef("", items)
+		return schema
+	case string:
+		return openapi3.NewStringSchema()
+	case bool:
+		return openapi3.NewBoolSchema()
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return openapi3.NewIntegerSchema()
+		}
+		return openapi3.NewFloat64Schema()
+	case nil:
+		schema := openapi3.NewSchema()
+		schema.Nullable = true
+		return schema
+	}
+	return openapi3.NewSchema()
+}
+
+func schemaType(s *openapi3.Schema) string {
+	if s.Type == nil || len(*s.Type) == 0 {
+		return ""
+	}
+	return (*s.Type)[0]
+}
+
+// mergeSchema widens a schema so that it also accepts what other accepts.
+// Properties are required only when every observation had them and types
+// that do not agree are left open.
+func mergeSchema(s *openapi3.Schema, other *openapi3.Schema) *openapi3.Schema {
+	switch {
+	case s == nil:
+		return other
+	case other == nil:
+		return s
+	}
+	s.Nullable = s.Nullable || other.Nullable
+	st, ot := schemaType(s), schemaType(other)
+	switch {
+	case ot == "":
+		return s
+	case st == "":
+		if s.Nullable {
+			other.Nullable = true
+		}
+		return other
+	case st == ot:
+	case st == "integer" && ot == "number":
+		s.Type = other.Type
+		return s
+	case st == "number" && ot == "integer":
+		return s
+	default:
+		result := openapi3.NewSchema()
+		result.Nullable = s.Nullable
+		return result
+	}
+	switch st {
+	case "object":
+		if s.Properties == nil {
+			s.Properties = make(openapi3.Schemas)
+		}
+		for key, ref := range other.Properties {
+			existing, ok := s.Properties[key]
+			switch {
+			case !ok:
+				s.Properties[key] = ref
+			case existing.Ref == "" && ref.Ref == "":
+				existing.Value = mergeSchema(existing.Value, ref.Value)
+			}
+		}
+		var required []string
+		for _, key := range s.Required {
+			for _, otherKey := range other.Required {
+				if key == otherKey {
+					required = append(required, key)
+				}
+			}
+		}
+		s.Required = required
+	case "array":
+		if s.Items == nil {
+			s.Items = other.Items
+		} else if other.Items != nil && s.Items.Ref == "" && other.Items.Ref == "" {
+			s.Items.Value = mergeSchema(s.Items.Value, other.Items.Value)
+		}
+	}
+	return s
+}
+
+func mergeSchemaRef(ref *openapi3.SchemaRef, schema *openapi3.Schema) *openapi3.SchemaRef {
+	if ref == nil {
+		return openapi3.NewSchemaRef("", schema)
+	}
+	if ref.Ref == "" {
+		ref.Value = mergeSchema(ref.Value, schema)
+	}
+	return ref
+}
+
+// addContent records a body under its media type with the recorded body as
+// a named example.
+func addContent(content openapi3.Content, contentType string, body string, example string) openapi3.Content {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || body == "" {
+		return content
+	}
+	if content == nil {
+		content = make(openapi3.Content)
+	}
+	media, ok := content[mediaType]
+	if !ok {
+		media = openapi3.NewMediaType()
+		content[mediaType] = media
+	}
+	var value any = body
+	schema := openapi3.NewStringSchema()
+	if doc, ok := decode(body); ok {
+		value = plain(doc)
+		schema = schemaOf(doc)
+	}
+	media.Schema = mergeSchemaRef(media.Schema, schema)
+	if media.Examples == nil {
+		media.Examples = make(openapi3.Examples)
+	}
+	if _, ok := media.Examples[example]; !ok {
+		media.Examples[example] = &openapi3.ExampleRef{Value: openapi3.NewExample(value)}
+	}
+	return content
+}
+
+func header(h map[string][]string, key string) string {
+	for k, values := range h {
+		if strings.EqualFold(k, key) && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+func findParam(params openapi3.Parameters, in string, name string) *openapi3.Parameter {
+	for _, ref := range params {
+		if ref.Value != nil && ref.Value.In == in && ref.Value.Name == name {
+			return ref.Value
+		}
+	}
+	return nil
+}
+
+// addParam adds a parameter or widens the schema of the one there is.
+func addParam(params openapi3.Parameters, param *openapi3.Parameter, value string) openapi3.Parameters {
+	schema := scalarSchema(value)
+	if existing := findParam(params, param.In, param.Name); existing != nil {
+		existing.Schema = mergeSchemaRef(existing.Schema, schema)
+		return params
+	}
+	param.Schema = openapi3.NewSchemaRef("", schema)
+	param.Example = value
+	if t := schemaType(schema); t == "integer" || t == "number" {
+		param.Example = plain(json.Number(value))
+	}
+	return append(params, &openapi3.ParameterRef{Value: param})
+}
+
+type synthesizer struct {
+	doc  *openapi3.T
+	ids  map[string]bool
+	vars map[string]string // recorded values of the variables
+}
+
+func (s *synthesizer) serverPath(u *url.URL) string {
+	for _, server := range s.doc.Servers {
+		base, err := url.Parse(server.URL)
+		if err != nil || base.Host != u.Host {
+			continue
+		}
+		prefix := strings.TrimSuffix(base.Path, "/")
+		if strings.HasPrefix(u.Path, prefix+"/") {
+			return strings.TrimPrefix(u.Path, prefix)
+		}
+	}
+	s.doc.AddServer(&openapi3.Server{URL: u.Scheme + "://" + u.Host})
+	return u.Path
+}
+
+func (s *synthesizer) example(value string) string {
+	if m := varSegment.FindStringSubmatch(value); m != nil {
+		if recorded, ok := s.vars[m[1]]; ok {
+			return recorded
+		}
+	}
+	return value
+}
+
+func (s *synthesizer) add(project string, h autodemo.History) {
+	u, err := url.Parse(h.Request.URL)
+	if err != nil || h.Request.Method == "" || h.Failure != "" {
+		return
+	}
+	// the url keeps the $NAME of variables unescaped.
+	path := s.serverPath(u)
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	t, ok := matchTemplate(s.doc.Paths, segments)
+	if !ok {
+		t = newTemplate(segments)
+	}
+	item := s.doc.Paths.Find(t.path)
+	if item == nil {
+		item = &openapi3.PathItem{}
+		s.doc.Paths.Set(t.path, item)
+	}
+	op := item.GetOperation(h.Request.Method)
+	if op == nil {
+		op = openapi3.NewOperation()
+		op.OperationID = operationID(h.Request.Method, t.path)
+		for n := 2; s.ids[op.OperationID]; n++ {
+			op.OperationID = fmt.Sprintf("%s%d", operationID(h.Request.Method, t.path), n)
+		}
+		s.ids[op.OperationID] = true
+		op.Responses = openapi3.NewResponses()
+		op.Responses.Delete("default")
+		item.SetOperation(h.Request.Method, op)
+	}
+	if h.Operation.Summary != "" && op.Summary == "" {
+		op.Summary = h.Operation.Summary
+	}
+	names := make([]string, 0, len(t.params))
+	for name := range t.params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if findParam(item.Parameters, openapi3.ParameterInPath, name) != nil {
+			continue
+		}
+		op.Parameters = addParam(op.Parameters, openapi3.NewPathParameter(name), s.example(t.params[name]))
+	}
+	query := u.Query()
+	for _, name := range sortedKeys(query) {
+		op.Parameters = addParam(op.Parameters, openapi3.NewQueryParameter(name), s.example(query.Get(name)))
+	}
+
+	example := fmt.Sprintf("%s-%03d", project, h.Index)
+	contentType := header(h.Request.Header, "Content-Type")
+	if contentType == "" {
+		contentType = header(h.Request.Hidden, "Content-Type")
+	}
+	if h.Request.Body != "" && len(h.Request.Form) == 0 {
+		if op.RequestBody == nil {
+			op.RequestBody = &openapi3.RequestBodyRef{Value: openapi3.NewRequestBody()}
+		}
+		if body := op.RequestBody.Value; body != nil {
+			body.Content = addContent(body.Content, contentType, h.Request.Body, example)
+		}
+	}
+	if h.Response.Status == 0 {
+		return
+	}
+	status := strconv.Itoa(h.Response.Status)
+	resp := op.Responses.Value(status)
+	if resp == nil {
+		resp = &openapi3.ResponseRef{Value: openapi3.NewResponse().WithDescription(http.StatusText(h.Response.Status))}
+		op.Responses.Set(status, resp)
+	}
+	if resp.Value != nil && len(h.Chunks) == 0 {
+		resp.Value.Content = addContent(resp.Value.Content, header(h.Response.Header, "Content-Type"), h.Response.Body, example)
+	}
+}
+
+func sortedKeys(values url.Values) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Synthesize infers the paths, parameters and body schemas of the recorded
+// traffic and merges them into existing, a YAML or JSON document that may be
+// empty. The recorded bodies are attached as examples named after the
+// project and step.
+func Synthesize(ctx context.Context, existing []byte, title string, recordings ...Recording) ([]byte, error) {
+	doc := &openapi3.T{
+		OpenAPI: "3.0.3",
+		Info:    &openapi3.Info{Title: title, Version: "0.0.1"},
+		Paths:   openapi3.NewPaths(),
+	}
+	if len(bytes.TrimSpace(existing)) > 0 {
+		loader := openapi3.NewLoader()
+		loader.Context = ctx
+		var err error
+		doc, err = loader.LoadFromData(existing)
+		if err != nil {
+			return nil, fmt.Errorf("could not load openapi document: %w", err)
+		}
+		if doc.Paths == nil {
+			doc.Paths = openapi3.NewPaths()
+		}
+	}
+	s := synthesizer{
+		doc:  doc,
+		ids:  make(map[string]bool),
+		vars: make(map[string]string),
+	}
+	for _, item := range doc.Paths.Map() {
+		for _, op := range item.Operations() {
+			s.ids[op.OperationID] = true
+		}
+	}
+	for _, recording := range recordings {
+		for _, h := range recording.Histories {
+			for _, v := range h.Extract {
+				s.vars[v.Name] = v.Value
+			}
+		}
+		for _, h := range recording.Histories {
+			s.add(recording.Project, h)
+		}
+	}
+	err := doc.Validate(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("synthesized an invalid document: %w", err)
+	}
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	err = enc.Encode(doc)
+	if err != nil {
+		return nil, err
+	}
+	err = enc.Close()
+	if err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -177,6 +177,8 @@ func (p *Provider) SignCSR(der []byte) ([]byte, error) {
 		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
 		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
 		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     csr.DNSNames,
+		IPAddresses:  csr.IPAddresses,
 	}
 	certDer, err := x509.CreateCertificate(rand.Reader, &serverTemplate, p.caCert, csr.PublicKey, p.caPrivateKey)
 	if err != nil {
@@ -0,0 +1,271 @@
+package poll
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/slcjordan/autodemo"
+)
+
+// minPolls is the shortest run of requests that is taken for polling.
+const minPolls = 3
+
+// terminalKeys name the fields that usually tell whether a job is done.
+var terminalKeys = []string{"status", "state", "phase", "done", "ready", "complete", "completed", "finished"}
+
+func pollable(h autodemo.History) bool {
+	return h.Request.Method != "" && h.Failure == "" && !h.Request.WebSocket &&
+		len(h.Chunks) == 0 && len(h.Request.Form) == 0 && h.Request.BodyFile == ""
+}
+
+// sameRequest ignores the headers, clients add request ids and timestamps.
+func sameRequest(a, b autodemo.History) bool {
+	return pollable(a) && pollable(b) &&
+		a.Request.Method == b.Request.Method &&
+		a.Request.URL == b.Request.URL &&
+		a.Request.Body == b.Request.Body
+}
+
+func sameResponse(a, b autodemo.History) bool {
+	return a.Response.Status == b.Response.Status && a.Response.Body == b.Response.Body
+}
+
+// Collapse replaces every run of consecutive requests that polled until the
+// response reached a terminal state with a single step. Reads of the
+// terminal state after that stay steps of their own.
+func Collapse(histories []autodemo.History) []autodemo.History {
+	var result []autodemo.History
+	for start := 0; start < len(histories); {
+		end := start + 1
+		for end < len(histories) && sameRequest(histories[start], histories[end]) {
+			end++
+		}
+		n := polls(histories[start:end])
+		if n < minPolls {
+			result = append(result, histories[start])
+			start++
+			continue
+		}
+		result = append(result, collapse(histories[start:start+n]))
+		start += n
+	}
+	return result
+}
+
+// polls counts the requests of a run that polled: up to the first response
+// with a status field that reached a value it did not have before and kept
+// until the end of the run, or else up to the last change of the response.
+func polls(run []autodemo.History) int {
+	for n := minPolls; n <= len(run); n++ {
+		l, terminal, ok := until(run[:n])
+		if ok && terminal && holds(l, run[n:]) {
+			return n
+		}
+	}
+	last := len(run) - 1
+	for last > 0 && sameResponse(run[last], run[last-1]) {
+		last--
+	}
+	return last + 1
+}
+
+func collapse(run []autodemo.History) autodemo.History {
+	first, h := run[0], run[len(run)-1]
+	poll := &autodemo.Poll{Count: len(run)}
+	if l, _, ok := until(run); ok {
+		poll.Until = fmt.Sprintf("%s == %s", jqPath(l.path), l.json)
+	}
+	var gaps []time.Duration
+	for i := 1; i < len(run); i++ {
+		if !run[i].Started.IsZero() && !run[i-1].Started.IsZero() {
+			gaps = append(gaps, run[i].Started.Sub(run[i-1].Started))
+		}
+	}
+	if len(gaps) > 0 {
+		sort.Slice(gaps, func(i, j int) bool { return gaps[i] < gaps[j] })
+		poll.Interval = gaps[len(gaps)/2]
+	}
+	if !first.Started.IsZero() && !h.Started.IsZero() {
+		h.ExecTime = h.Started.Add(h.ExecTime).Sub(first.Started)
+		h.Started = first.Started
+	}
+	h.Index = first.Index
+	h.Poll = poll
+	return h
+}
+
+type leaf struct {
+	path  []any
+	value any
+	json  []byte
+}
+
+// holds reports whether the responses still have the value of l.
+func holds(l leaf, histories []autodemo.History) bool {
+	for _, h := range histories {
+		doc, ok := decode(h.Response.Body)
+		if !ok {
+			return false
+		}
+		val, ok := lookup(doc, l.path)
+		if !ok || fmt.Sprint(val) != fmt.Sprint(l.value) {
+			return false
+		}
+	}
+	return true
+}
+
+func decode(body string) (any, bool) {
+	var doc any
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.UseNumber()
+	return doc, dec.Decode(&doc) == nil
+}
+
+func leaves(val any, p []any, f func(leaf)) {
+	switch v := val.(type) {
+	case map[string]any:
+		for key, child := range v {
+			leaves(child, append(p[:len(p):len(p)], key), f)
+		}
+	case []any:
+		for i, child := range v {
+			leaves(child, append(p[:len(p):len(p)], i), f)
+		}
+	default:
+		f(leaf{path: p, value: v})
+	}
+}
+
+func lookup(val any, p []any) (any, bool) {
+	for _, segment := range p {
+		switch s := segment.(type) {
+		case string:
+			obj, ok := val.(map[string]any)
+			if !ok {
+				return nil, false
+			}
+			val, ok = obj[s]
+			if !ok {
+				return nil, false
+			}
+		case int:
+			arr, ok := val.([]any)
+			if !ok || s >= len(arr) {
+				return nil, false
+			}
+			val = arr[s]
+		}
+	}
+	return val, true
+}
+
+func isTerminal(p []any) bool {
+	if len(p) == 0 {
+		return false
+	}
+	key, ok := p[len(p)-1].(string)
+	if !ok {
+		return false
+	}
+	for _, terminal := range terminalKeys {
+		if strings.EqualFold(key, terminal) {
+			return true
+		}
+	}
+	return false
+}
+
+// until finds a field of the last response that tells it apart from the
+// earlier ones. A field named like a status may go through several values
+// on the way, any other field has to flip from one value to the last.
+// Fields such as timestamps that change on every poll are never taken.
+// terminal is true when the field is named like a status.
+func until(run []autodemo.History) (l leaf, terminal bool, ok bool) {
+	last, ok := decode(run[len(run)-1].Response.Body)
+	if !ok {
+		return leaf{}, false, false
+	}
+	var earlier []any
+	for _, h := range run[:len(run)-1] {
+		doc, ok := decode(h.Response.Body)
+		if !ok {
+			return leaf{}, false, false
+		}
+		earlier = append(earlier, doc)
+	}
+	var terminals, flipped []leaf
+	leaves(last, nil, func(l leaf) {
+		values := make(map[string]bool)
+		for _, doc := range earlier {
+			val, ok := lookup(doc, l.path)
+			if !ok {
+				val = struct{}{}
+			}
+			values[fmt.Sprint(val)] = true
+		}
+		if values[fmt.Sprint(l.value)] {
+			return
+		}
+		switch {
+		case isTerminal(l.path):
+			terminals = append(terminals, l)
+		case len(values) == 1:
+			flipped = append(flipped, l)
+		}
+	})
+	for rank, candidates := range [][]leaf{terminals, flipped} {
+		if len(candidates) == 0 {
+			continue
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			if len(candidates[i].path) != len(candidates[j].path) {
+				return len(candidates[i].path) < len(candidates[j].path)
+			}
+			return jqPath(candidates[i].path) < jqPath(candidates[j].path)
+		})
+		l = candidates[0]
+		var err error
+		l.json, err = json.Marshal(l.value)
+		if err != nil {
+			return leaf{}, false, false
+		}
+		return l, rank == 0, true
+	}
+	return leaf{}, false, false
+}
+
+// jqPath writes p as a jq filter.
+func jqPath(p []any) string {
+	if len(p) == 0 {
+		return "."
+	}
+	var result strings.Builder
+	for _, segment := range p {
+		switch s := segment.(type) {
+		case int:
+			fmt.Fprintf(&result, "[%d]", s)
+		case string:
+			if isIdentifier(s) {
+				result.WriteString("." + s)
+			} else {
+				result.WriteString("[" + strconv.Quote(s) + "]")
+			}
+		}
+	}
+	return result.String()
+}
+
+func isIdentifier(key string) bool {
+	for i, r := range key {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return key != ""
+}
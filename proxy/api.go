@@ -0,0 +1,286 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/slcjordan/autodemo"
+	"github.com/slcjordan/autodemo/logger"
+	"github.com/slcjordan/autodemo/openapi"
+	"github.com/slcjordan/autodemo/transport"
+)
+
+// api is the JSON version of the dashboard actions for scripts. Unlike the
+// dashboard it answers with the outcome instead of redirecting.
+type api struct {
+	m *Manager
+}
+
+func newAPI(m *Manager) http.Handler {
+	a := api{m: m}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/proxies", a.ListProxies)
+	mux.HandleFunc("POST /api/v1/proxies", a.CreateProxy)
+	mux.HandleFunc("PUT /api/v1/proxies/{id}", a.UpdateProxy)
+	mux.HandleFunc("DELETE /api/v1/proxies/{id}", a.DeleteProxy)
+	mux.HandleFunc("POST /api/v1/proxies/{id}/stop", a.StopProxy)
+	mux.HandleFunc("POST /api/v1/proxies/{id}/restart", a.RestartProxy)
+	mux.HandleFunc("GET /api/v1/recording", a.Recording)
+	mux.HandleFunc("POST /api/v1/recording", a.StartRecording)
+	mux.HandleFunc("POST /api/v1/recording/stop", a.StopRecording)
+	mux.HandleFunc("GET /api/v1/projects", a.ListProjects)
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, errors.New("no such endpoint"))
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
+
+// saved answers with the proxy with the given id once the proxies are saved.
+func (a api) saved(w http.ResponseWriter, r *http.Request, status int, id string) {
+	err := a.m.saveProxies()
+	if err != nil {
+		logger.Errorf(r.Context(), "could not save proxies: %s", err)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	for _, p := range a.m.Proxies() {
+		if p.ID == id {
+			writeJSON(w, status, p)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a api) ListProxies(w http.ResponseWriter, r *http.Request) {
+	proxies := a.m.Proxies()
+	if proxies == nil {
+		proxies = []Proxy{}
+	}
+	writeJSON(w, http.StatusOK, proxies)
+}
+
+// CreateProxy takes a ProxyDefinition. Filters that are left out are the
+// default ones, as in the dashboard form. A proxy that cannot start, most
+// often because its port is taken, is not kept and the answer is a 409 with
+// the start error.
+func (a api) CreateProxy(w http.ResponseWriter, r *http.Request) {
+	def := newDefinition()
+	err := json.NewDecoder(r.Body).Decode(&def)
+	if err != nil {
+		logger.Infof(r.Context(), "could not decode proxy: %s", err)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	p, err := def.Proxy()
+	if err != nil {
+		logger.Infof(r.Context(), "could not read proxy: %s", err)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	p.ID = newID()
+	err = a.m.NewProxy(p)
+	if errors.Is(err, ErrInvalidProxy) {
+		logger.Infof(r.Context(), "could not create new proxy: %s", err)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		logger.Errorf(r.Context(), "could not create new proxy: %s", err)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	startErr := a.m.startError(p.ID)
+	if startErr != nil {
+		err = a.m.DeleteProxy(r.Context(), p.ID)
+		if err != nil {
+			logger.Errorf(r.Context(), "could not delete failed proxy: %s", err)
+		}
+		writeError(w, http.StatusConflict, startErr)
+		return
+	}
+	a.saved(w, r, http.StatusCreated, p.ID)
+}
+
+// UpdateProxy takes the whole ProxyDefinition, as CreateProxy does. The
+// proxy keeps running as it was when the new definition does not work, and
+// the answer is a 409 when it could not start.
+func (a api) UpdateProxy(w http.ResponseWriter, r *http.Request) {
+	def := newDefinition()
+	err := json.NewDecoder(r.Body).Decode(&def)
+	if err != nil {
+		logger.Infof(r.Context(), "could not decode proxy: %s", err)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	def.ID = r.PathValue("id")
+	p, err := def.Proxy()
+	if err != nil {
+		logger.Infof(r.Context(), "could not read proxy: %s", err)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	err = a.m.UpdateProxy(r.Context(), p)
+	switch {
+	case errors.Is(err, ErrUnknownProxy):
+		writeError(w, http.StatusNotFound, err)
+		return
+	case errors.Is(err, ErrInvalidProxy):
+		logger.Infof(r.Context(), "could not update proxy: %s", err)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	case errors.Is(err, ErrNotStarted):
+		logger.Infof(r.Context(), "could not update proxy: %s", err)
+		writeError(w, http.StatusConflict, err)
+		return
+	case err != nil:
+		logger.Errorf(r.Context(), "could not update proxy: %s", err)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	a.saved(w, r, http.StatusOK, p.ID)
+}
+
+func (a api) DeleteProxy(w http.ResponseWriter, r *http.Request) {
+	err := a.m.DeleteProxy(r.Context(), r.PathValue("id"))
+	if errors.Is(err, ErrUnknownProxy) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		logger.Errorf(r.Context(), "could not delete proxy: %s", err)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	a.saved(w, r, http.StatusNoContent, "")
+}
+
+// StopProxy answers with the stopped proxy.
+func (a api) StopProxy(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	err := a.m.StopProxy(r.Context(), id)
+	if errors.Is(err, ErrUnknownProxy) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		logger.Errorf(r.Context(), "could not stop proxy: %s", err)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	a.saved(w, r, http.StatusOK, id)
+}
+
+// RestartProxy answers with the restarted proxy. A proxy that could not
+// start is Failed and has the Error.
+func (a api) RestartProxy(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	err := a.m.RestartProxy(r.Context(), id)
+	if errors.Is(err, ErrUnknownProxy) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		logger.Errorf(r.Context(), "could not restart proxy: %s", err)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	a.saved(w, r, http.StatusOK, id)
+}
+
+type recording struct {
+	Project   string
+	Recording bool
+}
+
+func (a api) Recording(w http.ResponseWriter, r *http.Request) {
+	name, ok := a.m.Recorder.Recording()
+	writeJSON(w, http.StatusOK, recording{Project: name, Recording: ok})
+}
+
+// StartRecording takes the name of the project to record.
+func (a api) StartRecording(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name string
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		logger.Infof(r.Context(), "could not decode project: %s", err)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if body.Name == "" {
+		writeError(w, http.StatusBadRequest, errors.New("project name is required"))
+		return
+	}
+	if _, ok := a.m.Recorder.Recording(); ok {
+		writeError(w, http.StatusConflict, errors.New("already recording"))
+		return
+	}
+	err = a.m.Recorder.StartProject(r.Context(), body.Name)
+	if err != nil {
+		logger.Infof(r.Context(), "could not start project: %s", err)
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, recording{Project: body.Name, Recording: true})
+}
+
+// StopRecording takes the settings of the recorded project, the ones of the
+// dashboard form.
+func (a api) StopRecording(w http.ResponseWriter, r *http.Request) {
+	var project autodemo.Project
+	err := json.NewDecoder(r.Body).Decode(&project)
+	if err != nil {
+		logger.Infof(r.Context(), "could not decode project: %s", err)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if project.OpenAPI != "" {
+		_, err = openapi.Parse(r.Context(), []byte(project.OpenAPI))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	err = transport.CheckHeaderGlobs(project.HideHeaders)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	name, ok := a.m.Recorder.Recording()
+	if !ok {
+		writeError(w, http.StatusConflict, errors.New("not recording"))
+		return
+	}
+	err = a.m.Recorder.StopProject(r.Context(), project)
+	if err != nil {
+		logger.Errorf(r.Context(), "could not save project: %s", err)
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, recording{Project: name, Recording: false})
+}
+
+func (a api) ListProjects(w http.ResponseWriter, r *http.Request) {
+	projects := a.m.projects(r.Context())
+	if projects == nil {
+		projects = []Project{}
+	}
+	writeJSON(w, http.StatusOK, projects)
+}
@@ -0,0 +1,210 @@
+package proxy
+
+import (
+	"container/list"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/slcjordan/autodemo/logger"
+	"github.com/slcjordan/autodemo/transport"
+)
+
+// maxCerts is how many host certificates are kept for the next tunnels.
+const maxCerts = 128
+
+// forwardProxy serves clients that use it as HTTP_PROXY and HTTPS_PROXY.
+// CONNECT tunnels are decrypted with a certificate for the host they go to
+// so that the requests in them are recorded with their real URLs.
+type forwardProxy struct {
+	next http.Handler
+	pki  PKIProvider
+
+	mu      sync.Mutex // guards certs, recent and tunnels
+	certs   map[string]*list.Element
+	recent  *list.List // of *hostCert, most recently used first
+	tunnels map[*http.Server]struct{}
+}
+
+type hostCert struct {
+	host string
+	cert *tls.Certificate
+}
+
+func newForwardProxy(next http.Handler, pki PKIProvider) *forwardProxy {
+	return &forwardProxy{
+		next:    next,
+		pki:     pki,
+		certs:   make(map[string]*list.Element),
+		recent:  list.New(),
+		tunnels: make(map[*http.Server]struct{}),
+	}
+}
+
+// closeTunnels closes the tunnels that are open. The server of the proxy
+// does not see them once they are hijacked, so it cannot wait for them.
+func (f *forwardProxy) closeTunnels() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for tunnel := range f.tunnels {
+		tunnel.Close()
+	}
+	clear(f.tunnels)
+}
+
+func (f *forwardProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		f.connect(w, r)
+		return
+	}
+	if !r.URL.IsAbs() {
+		http.Error(w, "use this address as HTTP_PROXY or HTTPS_PROXY", http.StatusBadRequest)
+		return
+	}
+	f.next.ServeHTTP(w, r)
+}
+
+// connect answers a CONNECT request and serves the requests of the tunnel
+// as if they were sent to the host of the tunnel.
+func (f *forwardProxy) connect(w http.ResponseWriter, r *http.Request) {
+	host, port, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host, port = r.Host, "443"
+	}
+	if f.pki == nil {
+		http.Error(w, "https is not set up", http.StatusNotImplemented)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "cannot tunnel", http.StatusInternalServerError)
+		return
+	}
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		logger.Errorf(r.Context(), "could not hijack CONNECT %s: %s", r.Host, err)
+		return
+	}
+	_, err = conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+	if err != nil {
+		conn.Close()
+		return
+	}
+	tlsConn := tls.Server(conn, &tls.Config{
+		NextProtos: []string{"http/1.1"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			name := hello.ServerName
+			if name == "" {
+				name = host
+			}
+			return f.certificate(name)
+		},
+	})
+	authority := host
+	if port != "443" {
+		authority = net.JoinHostPort(host, port)
+	}
+	config := transport.ConfigFrom(r.Context())
+	l := newConnListener(tlsConn)
+	tunnel := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.URL.Scheme = "https"
+			r.URL.Host = authority
+			f.next.ServeHTTP(w, r.WithContext(transport.WithConfig(r.Context(), config)))
+		}),
+		ConnState: func(_ net.Conn, state http.ConnState) {
+			if state == http.StateClosed || state == http.StateHijacked {
+				l.Close()
+			}
+		},
+	}
+	f.mu.Lock()
+	f.tunnels[tunnel] = struct{}{}
+	f.mu.Unlock()
+	go func() {
+		tunnel.Serve(l)
+		f.mu.Lock()
+		delete(f.tunnels, tunnel)
+		f.mu.Unlock()
+	}()
+}
+
+// certificate signs a certificate for host with the end entity key and
+// keeps it for the next tunnels. Only the most recently used maxCerts are
+// kept.
+func (f *forwardProxy) certificate(host string) (*tls.Certificate, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if elem, ok := f.certs[host]; ok {
+		f.recent.MoveToFront(elem)
+		return elem.Value.(*hostCert).cert, nil
+	}
+	csrTemplate := x509.CertificateRequest{
+		Subject: pkix.Name{
+			CommonName:   host,
+			Organization: []string{"My Server"},
+		},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		csrTemplate.IPAddresses = []net.IP{ip}
+	} else {
+		csrTemplate.DNSNames = []string{host}
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &csrTemplate, f.pki.EEPrivateKey())
+	if err != nil {
+		return nil, err
+	}
+	der, err := f.pki.SignCSR(csr)
+	if err != nil {
+		return nil, err
+	}
+	cert := &tls.Certificate{
+		PrivateKey:  f.pki.EEPrivateKey(),
+		Certificate: [][]byte{der},
+	}
+	f.certs[host] = f.recent.PushFront(&hostCert{host: host, cert: cert})
+	if f.recent.Len() > maxCerts {
+		oldest := f.recent.Remove(f.recent.Back()).(*hostCert)
+		delete(f.certs, oldest.host)
+	}
+	return cert, nil
+}
+
+// connListener accepts a single connection and then waits until it is
+// closed, so that an http.Server can serve it.
+type connListener struct {
+	conn     net.Conn
+	accepted bool
+	done     chan struct{}
+	once     sync.Once
+}
+
+func newConnListener(conn net.Conn) *connListener {
+	return &connListener{
+		conn: conn,
+		done: make(chan struct{}),
+	}
+}
+
+// Accept is only called by the one goroutine of http.Server.Serve.
+func (l *connListener) Accept() (net.Conn, error) {
+	if !l.accepted {
+		l.accepted = true
+		return l.conn, nil
+	}
+	<-l.done
+	return nil, net.ErrClosed
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
@@ -7,17 +7,29 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"html/template"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/slcjordan/autodemo"
+	"github.com/slcjordan/autodemo/export"
+	"github.com/slcjordan/autodemo/har"
 	"github.com/slcjordan/autodemo/logger"
+	"github.com/slcjordan/autodemo/openapi"
+	"github.com/slcjordan/autodemo/render"
+	"github.com/slcjordan/autodemo/shell"
+	"github.com/slcjordan/autodemo/transport"
 )
 
 type PKIProvider interface {
@@ -31,15 +43,19 @@ type Status string
 const (
 	Running Status = "running"
 	Stopped Status = "stopped"
+	// Failed proxies could not listen or stopped serving on their own.
+	Failed Status = "failed"
 )
 
 type ProjectRecorder interface {
 	StartProject(ctx context.Context, name string) error
-	StopProject(ctx context.Context, desc string) error
+	StopProject(ctx context.Context, project autodemo.Project) error
+	ImportProject(ctx context.Context, project autodemo.Project, archive *har.Archive) error
 	Recording() (string, bool)
 }
 
 type Proxy struct {
+	ID              string
 	ListenHost      string
 	ListenPort      string
 	ListenScheme    string
@@ -47,12 +63,128 @@ type Proxy struct {
 	ForwardPort     string
 	ForwardScheme   string
 	ForwardInsecure bool
+	Filters         []transport.FilterRule
+	// Routes pick the upstream by host and path. Requests that match no
+	// route go to the forward address.
+	Routes          []Route
+	RequestHeaders  transport.HeaderPolicy
+	ResponseHeaders transport.HeaderPolicy
+	OpenAPI         string
+	// Status and Error are filled in by Manager.Proxies. NewProxy leaves a
+	// Stopped proxy stopped.
+	Status Status
+	Error  string
+}
+
+// Forward is the server the proxy forwards to, written as a URL.
+func (p Proxy) Forward() string {
+	if p.ForwardHost == "" && len(p.Routes) > 0 {
+		return "routes only"
+	}
+	switch p.ForwardScheme {
+	case "unix":
+		return "unix://" + p.ForwardHost
+	case "proxy":
+		return "any host"
+	}
+	return p.ForwardScheme + "://" + p.ForwardHost + ":" + p.ForwardPort
+}
+
+var (
+	ErrInvalidProxy = errors.New("invalid proxy")
+	ErrUnknownProxy = errors.New("unknown proxy")
+	ErrNotStarted   = errors.New("proxy could not start")
+)
+
+// ProxyDefinition is a proxy the way the dashboard form and the API take
+// it, with the filter and header rules written as text. It is also the way
+// proxies are saved.
+type ProxyDefinition struct {
+	ID              string
+	ListenHost      string
+	ListenPort      string
+	ListenScheme    string
+	ForwardHost     string
+	ForwardPort     string
+	ForwardScheme   string
+	ForwardInsecure bool
+	Filters         string
+	Routes          string
+	Headers         string
+	OpenAPI         string
+	Stopped         bool
+}
+
+func (d ProxyDefinition) Proxy() (Proxy, error) {
+	filters, err := transport.ParseFilterRules(d.Filters)
+	if err != nil {
+		return Proxy{}, fmt.Errorf("%w: %w", ErrInvalidProxy, err)
+	}
+	routes, err := ParseRoutes(d.Routes)
+	if err != nil {
+		return Proxy{}, fmt.Errorf("%w: %w", ErrInvalidProxy, err)
+	}
+	requestHeaders, responseHeaders, err := transport.ParseHeaderPolicies(d.Headers)
+	if err != nil {
+		return Proxy{}, fmt.Errorf("%w: %w", ErrInvalidProxy, err)
+	}
+	var status Status
+	if d.Stopped {
+		status = Stopped
+	}
+	return Proxy{
+		ID:              d.ID,
+		ListenHost:      d.ListenHost,
+		ListenPort:      d.ListenPort,
+		ListenScheme:    d.ListenScheme,
+		ForwardHost:     d.ForwardHost,
+		ForwardPort:     d.ForwardPort,
+		ForwardScheme:   d.ForwardScheme,
+		ForwardInsecure: d.ForwardInsecure,
+		Filters:         filters,
+		Routes:          routes,
+		RequestHeaders:  requestHeaders,
+		ResponseHeaders: responseHeaders,
+		OpenAPI:         d.OpenAPI,
+		Status:          status,
+	}, nil
+}
+
+// newDefinition is what the dashboard form starts with.
+func newDefinition() ProxyDefinition {
+	return ProxyDefinition{
+		ListenHost:    "127.0.0.1",
+		ListenScheme:  "http",
+		ForwardScheme: "http",
+		Filters:       transport.FormatFilterRules(transport.DefaultFilterRules()),
+	}
+}
+
+func (p Proxy) Definition() ProxyDefinition {
+	return ProxyDefinition{
+		ID:              p.ID,
+		ListenHost:      p.ListenHost,
+		ListenPort:      p.ListenPort,
+		ListenScheme:    p.ListenScheme,
+		ForwardHost:     p.ForwardHost,
+		ForwardPort:     p.ForwardPort,
+		ForwardScheme:   p.ForwardScheme,
+		ForwardInsecure: p.ForwardInsecure,
+		Filters:         transport.FormatFilterRules(p.Filters),
+		Routes:          FormatRoutes(p.Routes),
+		Headers:         transport.FormatHeaderPolicies(p.RequestHeaders, p.ResponseHeaders),
+		OpenAPI:         p.OpenAPI,
+		Stopped:         p.Status == Stopped,
+	}
 }
 
 type Project struct {
-	Name  string
-	Error bool
-	Done  bool
+	Name    string
+	Error   bool
+	Done    bool
+	Archive bool
+	Replay  bool
+	Export  bool
 }
 
 func fileExists(ctx context.Context, parts ...string) bool {
@@ -68,13 +200,17 @@ func fileExists(ctx context.Context, parts ...string) bool {
 }
 
 type Manager struct {
-	mu        sync.Mutex // guards servers
-	servers   map[*http.Server]Status
+	mu        sync.Mutex // guards servers, proxies
+	servers   map[string]*server
 	proxies   []Proxy
+	api       http.Handler
 	fs        http.Handler
 	projectFS http.Handler
+	archiveFS http.Handler
 	tmpl      *template.Template
 	lastError error
+	// proxyFile keeps the proxies across restarts, see SaveProxies.
+	proxyFile string
 
 	SecureTransport   http.RoundTripper
 	InsecureTransport http.RoundTripper
@@ -82,21 +218,63 @@ type Manager struct {
 	Recorder          ProjectRecorder
 }
 
-func NewManager(secureTransport http.RoundTripper, insecureTransport http.RoundTripper, pkiProvider PKIProvider, recorder ProjectRecorder) *Manager {
+// NewManager starts the proxies saved in proxyFile. Proxies are not saved
+// when proxyFile is empty.
+func NewManager(secureTransport http.RoundTripper, insecureTransport http.RoundTripper, pkiProvider PKIProvider, recorder ProjectRecorder, proxyFile string) *Manager {
 	tmpl, err := template.ParseFiles("../../ui/public/pages/dashboard/index.html")
 	if err != nil {
 		panic(err)
 	}
-	return &Manager{
-		servers:           make(map[*http.Server]Status),
+	m := &Manager{
+		servers:           make(map[string]*server),
 		SecureTransport:   secureTransport,
 		InsecureTransport: insecureTransport,
 		PKIProvider:       pkiProvider,
 		Recorder:          recorder,
 		fs:                http.FileServer(http.Dir("../../ui/public")),
 		projectFS:         http.StripPrefix("/projects", http.FileServer(http.Dir("../../projects"))),
+		archiveFS:         http.StripPrefix("/archives", http.FileServer(http.Dir("../../archives"))),
 		tmpl:              tmpl,
+		proxyFile:         proxyFile,
+	}
+	m.api = newAPI(m)
+	m.restoreProxies()
+	return m
+}
+
+func (m *Manager) restoreProxies() {
+	if m.proxyFile == "" {
+		return
+	}
+	ctx := context.Background()
+	definitions, err := LoadProxies(m.proxyFile)
+	if err != nil {
+		logger.Errorf(ctx, "could not load proxies: %s", err)
+		m.lastError = err
+		return
+	}
+	for _, d := range definitions {
+		p, err := d.Proxy()
+		if err == nil {
+			err = m.NewProxy(p)
+		}
+		if err != nil {
+			logger.Errorf(ctx, "could not restore proxy %s: %s", d.ID, err)
+			m.lastError = err
+		}
+	}
+}
+
+// saveProxies writes the proxies to the proxy file.
+func (m *Manager) saveProxies() error {
+	if m.proxyFile == "" {
+		return nil
 	}
+	var definitions []ProxyDefinition
+	for _, p := range m.Proxies() {
+		definitions = append(definitions, p.Definition())
+	}
+	return SaveProxies(m.proxyFile, definitions)
 }
 
 type errorWrapper []error
@@ -109,7 +287,49 @@ func (e errorWrapper) Unwrap() []error {
 	return e
 }
 
+// projects lists the recorded projects with what has been made of them.
+func (m *Manager) projects(ctx context.Context) []Project {
+	projectDir := "../../projects"
+	projectFiles, err := os.ReadDir(projectDir)
+	if err != nil {
+		logger.Errorf(ctx, "could not read projects dir %q: %s", projectDir, err)
+	}
+	var projects []Project
+	for _, f := range projectFiles {
+		if f.Name() == ".gitinclude" {
+			continue
+		}
+		projects = append(projects, Project{
+			Name:    f.Name(),
+			Error:   fileExists(ctx, projectDir, f.Name(), "error.txt"),
+			Done:    fileExists(ctx, projectDir, f.Name(), "combined-with-fade.webm"),
+			Archive: fileExists(ctx, "../../archives", f.Name()+".har"),
+			Replay:  fileExists(ctx, projectDir, f.Name(), "replay.html"),
+			Export:  fileExists(ctx, projectDir, f.Name(), export.HurlFile),
+		})
+	}
+	return projects
+}
+
+func (m *Manager) Proxies() []Proxy {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	proxies := append([]Proxy(nil), m.proxies...)
+	for i, p := range proxies {
+		s := m.servers[p.ID]
+		proxies[i].Status = s.status
+		if s.err != nil {
+			proxies[i].Error = s.err.Error()
+		}
+	}
+	return proxies
+}
+
 func (m *Manager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		m.api.ServeHTTP(w, r)
+		return
+	}
 	if r.Method == http.MethodPost {
 		switch r.URL.Query().Get("action") {
 		case "record":
@@ -118,6 +338,10 @@ func (m *Manager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			m.StopProject(w, r)
 		case "proxy":
 			m.HandleNewProxyRequest(w, r)
+		case "stop-proxy", "restart-proxy", "delete-proxy":
+			m.HandleProxyActionRequest(w, r)
+		case "import":
+			m.ImportProject(w, r)
 		}
 		http.Redirect(w, r, "/pages/dashboard", http.StatusSeeOther)
 	}
@@ -126,22 +350,6 @@ func (m *Manager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case "/", "/index.html":
 		http.Redirect(w, r, "/pages/dashboard", http.StatusPermanentRedirect)
 	case "/pages/dashboard/":
-		projectDir := "../../projects"
-		projectFiles, err := os.ReadDir(projectDir)
-		if err != nil {
-			logger.Errorf(r.Context(), "could not read projects dir %q: %s", projectDir, err)
-		}
-		var projects []Project
-		for _, f := range projectFiles {
-			if f.Name() == ".gitinclue" {
-				continue
-			}
-			projects = append(projects, Project{
-				Name:  f.Name(),
-				Error: fileExists(r.Context(), projectDir, f.Name(), "error.txt"),
-				Done:  fileExists(r.Context(), projectDir, f.Name(), "combined-with-fade.webm"),
-			})
-		}
 		var lastError string
 		if m.lastError != nil {
 			lastError = m.lastError.Error()
@@ -151,17 +359,23 @@ func (m *Manager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
 		w.Header().Set("Pragma", "no-cache")
 		w.Header().Set("Expires", "0")
-		err = m.tmpl.Execute(w, struct {
+		err := m.tmpl.Execute(w, struct {
 			Proxies     []Proxy
 			Recording   bool
 			ProjectName string
 			Projects    []Project
+			Renderers   []string
+			Shells      []shell.Dialect
+			Form        ProxyDefinition
 			LastError   string
 		}{
-			Proxies:     m.proxies,
+			Proxies:     m.Proxies(),
 			Recording:   recording,
 			ProjectName: name,
-			Projects:    projects,
+			Projects:    m.projects(r.Context()),
+			Renderers:   render.Names(),
+			Shells:      shell.Dialects(),
+			Form:        m.form(r.URL.Query().Get("edit")),
 			LastError:   lastError,
 		})
 		if err != nil {
@@ -173,9 +387,23 @@ func (m *Manager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		m.projectFS.ServeHTTP(w, r)
 		return
 	}
+	if strings.HasPrefix(path, "/archives") {
+		m.archiveFS.ServeHTTP(w, r)
+		return
+	}
 	m.fs.ServeHTTP(w, r)
 }
 
+// form is the definition of the proxy being edited, or of a new one.
+func (m *Manager) form(id string) ProxyDefinition {
+	for _, p := range m.Proxies() {
+		if id != "" && p.ID == id {
+			return p.Definition()
+		}
+	}
+	return newDefinition()
+}
+
 func (m *Manager) Shutdown(ctx context.Context) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -183,7 +411,13 @@ func (m *Manager) Shutdown(ctx context.Context) error {
 	var shutdownErrors errorWrapper
 
 	var wg sync.WaitGroup
-	for s, _ := range m.servers {
+	for _, s := range m.servers {
+		if s.forward != nil {
+			s.forward.closeTunnels()
+		}
+		if s.http == nil {
+			continue
+		}
 		wg.Add(1)
 		go func(s *http.Server) {
 			defer wg.Done()
@@ -194,7 +428,7 @@ func (m *Manager) Shutdown(ctx context.Context) error {
 				shutdownErrors = append(shutdownErrors, err)
 				shutdownMu.Unlock()
 			}
-		}(s)
+		}(s.http)
 	}
 	wg.Wait()
 	if shutdownErrors != nil {
@@ -221,6 +455,44 @@ func (m *Manager) StartProject(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func projectFromForm(r *http.Request) (autodemo.Project, error) {
+	project := autodemo.Project{
+		Desc:         r.FormValue("project_desc"),
+		Renderer:     r.FormValue("project_renderer"),
+		Languages:    r.Form["project_languages"],
+		Shell:        r.FormValue("project_shell"),
+		Spinner:      r.FormValue("project_spinner") != "",
+		DropFailures: r.FormValue("project_drop_failures") != "",
+		OpenAPI:      r.FormValue("project_openapi"),
+		HideHeaders:  strings.Fields(r.FormValue("project_hide_headers")),
+	}
+	var err error
+	if project.OpenAPI != "" {
+		_, err = openapi.Parse(r.Context(), []byte(project.OpenAPI))
+		if err != nil {
+			return project, err
+		}
+	}
+	err = transport.CheckHeaderGlobs(project.HideHeaders)
+	if err != nil {
+		return project, err
+	}
+	if scale := r.FormValue("project_latency_scale"); scale != "" {
+		project.LatencyScale, err = strconv.ParseFloat(scale, 64)
+		if err != nil {
+			return project, fmt.Errorf("could not parse latency scale %q: %w", scale, err)
+		}
+	}
+	if seconds := r.FormValue("project_max_latency"); seconds != "" {
+		maxLatency, err := strconv.ParseFloat(seconds, 64)
+		if err != nil {
+			return project, fmt.Errorf("could not parse max latency %q: %w", seconds, err)
+		}
+		project.MaxLatency = time.Duration(maxLatency * float64(time.Second))
+	}
+	return project, nil
+}
+
 func (m *Manager) StopProject(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
@@ -229,8 +501,14 @@ func (m *Manager) StopProject(w http.ResponseWriter, r *http.Request) {
 		m.lastError = err
 		return
 	}
-	desc := r.FormValue("project_desc")
-	err = m.Recorder.StopProject(r.Context(), desc)
+	project, err := projectFromForm(r)
+	if err != nil {
+		logger.Infof(r.Context(), "could not read project form: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		m.lastError = err
+		return
+	}
+	err = m.Recorder.StopProject(r.Context(), project)
 	if err != nil {
 		logger.Errorf(r.Context(), "could not save project: %s", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -239,6 +517,46 @@ func (m *Manager) StopProject(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (m *Manager) ImportProject(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseMultipartForm(32 << 20)
+	if err != nil {
+		logger.Infof(r.Context(), "could not parse multipart form: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		m.lastError = err
+		return
+	}
+	file, _, err := r.FormFile("project_har")
+	if err != nil {
+		logger.Infof(r.Context(), "could not read har upload: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		m.lastError = err
+		return
+	}
+	defer file.Close()
+	archive, err := har.Read(file)
+	if err != nil {
+		logger.Infof(r.Context(), "could not decode har upload: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		m.lastError = err
+		return
+	}
+	project, err := projectFromForm(r)
+	if err != nil {
+		logger.Infof(r.Context(), "could not read project form: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		m.lastError = err
+		return
+	}
+	project.Name = r.FormValue("project_name")
+	err = m.Recorder.ImportProject(r.Context(), project, archive)
+	if err != nil {
+		logger.Errorf(r.Context(), "could not import project: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		m.lastError = err
+		return
+	}
+}
+
 func (m *Manager) HandleNewProxyRequest(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
@@ -247,47 +565,208 @@ func (m *Manager) HandleNewProxyRequest(w http.ResponseWriter, r *http.Request)
 		m.lastError = err
 		return
 	}
-	listenHost := r.FormValue("listen_host")
-	listenPort := r.FormValue("listen_port")
-	listenScheme := r.FormValue("listen_scheme")
-	forwardHost := r.FormValue("forward_host")
-	forwardPort := r.FormValue("forward_port")
-	forwardScheme := r.FormValue("forward_scheme")
-	forwardInsecure := r.FormValue("forward_insecure")
+	p, err := ProxyDefinition{
+		ID:              r.FormValue("id"),
+		ListenHost:      r.FormValue("listen_host"),
+		ListenPort:      r.FormValue("listen_port"),
+		ListenScheme:    r.FormValue("listen_scheme"),
+		ForwardHost:     r.FormValue("forward_host"),
+		ForwardPort:     r.FormValue("forward_port"),
+		ForwardScheme:   r.FormValue("forward_scheme"),
+		ForwardInsecure: r.FormValue("forward_insecure") == "on",
+		Filters:         r.FormValue("filters"),
+		Routes:          r.FormValue("routes"),
+		Headers:         r.FormValue("headers"),
+		OpenAPI:         r.FormValue("openapi"),
+	}.Proxy()
+	if err != nil {
+		logger.Infof(r.Context(), "could not read proxy form: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		m.lastError = err
+		return
+	}
+	if p.ID != "" {
+		err = m.UpdateProxy(r.Context(), p)
+	} else {
+		err = m.NewProxy(p)
+	}
+	if err != nil {
+		m.lastError = err
+		logger.Errorf(r.Context(), "could not save proxy: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		m.lastError = err
+		return
+	}
+	err = m.saveProxies()
+	if err != nil {
+		logger.Errorf(r.Context(), "could not save proxies: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		m.lastError = err
+		return
+	}
+}
 
-	err = m.NewProxy(listenHost, listenPort, listenScheme, forwardHost, forwardPort, forwardScheme, forwardInsecure)
+// HandleProxyActionRequest stops, restarts or deletes the proxy with the id
+// of the form.
+func (m *Manager) HandleProxyActionRequest(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		logger.Infof(r.Context(), "could not parse http form: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		m.lastError = err
+		return
+	}
+	id := r.FormValue("id")
+	action := r.URL.Query().Get("action")
+	switch action {
+	case "stop-proxy":
+		err = m.StopProxy(r.Context(), id)
+	case "restart-proxy":
+		err = m.RestartProxy(r.Context(), id)
+	case "delete-proxy":
+		err = m.DeleteProxy(r.Context(), id)
+	}
+	if errors.Is(err, ErrUnknownProxy) {
+		logger.Infof(r.Context(), "could not find proxy %s", id)
+		w.WriteHeader(http.StatusNotFound)
+		m.lastError = err
+		return
+	}
 	if err != nil {
+		logger.Errorf(r.Context(), "could not %s proxy %s: %s", strings.TrimSuffix(action, "-proxy"), id, err)
+		w.WriteHeader(http.StatusInternalServerError)
 		m.lastError = err
-		logger.Errorf(r.Context(), "could not create new proxy: %s", err)
+		return
+	}
+	err = m.saveProxies()
+	if err != nil {
+		logger.Errorf(r.Context(), "could not save proxies: %s", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		m.lastError = err
 		return
 	}
-	// TODO save
 }
 
-func (m *Manager) NewProxy(listenHost, listenPort, listenScheme, forwardHost, forwardPort, forwardScheme, forwardInsecure string) error {
+func newID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (m *Manager) NewProxy(p Proxy) error {
+	if p.ListenPort == "" || (p.ForwardHost == "" && p.ForwardScheme != "proxy" && len(p.Routes) == 0) {
+		return fmt.Errorf("%w: listen port and forward address or routes are required", ErrInvalidProxy)
+	}
+	if p.ForwardScheme == "proxy" && len(p.Routes) > 0 {
+		return fmt.Errorf("%w: a forward proxy has no routes", ErrInvalidProxy)
+	}
+	routes, err := newRouter(p.Routes)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidProxy, err)
+	}
+	switch p.ListenScheme {
+	case "http", "https":
+	default:
+		return fmt.Errorf("%w: unknown listen scheme %q", ErrInvalidProxy, p.ListenScheme)
+	}
+	switch p.ForwardScheme {
+	case "http", "https", "h2c", "unix", "proxy":
+	default:
+		return fmt.Errorf("%w: unknown forward scheme %q", ErrInvalidProxy, p.ForwardScheme)
+	}
+	if p.ID == "" {
+		p.ID = newID()
+	}
+	filter, err := transport.NewFilter(p.Filters...)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidProxy, err)
+	}
+	config := transport.Config{
+		Filter:          filter,
+		RequestHeaders:  &p.RequestHeaders,
+		ResponseHeaders: &p.ResponseHeaders,
+	}
+	if p.OpenAPI != "" {
+		config.OpenAPI, err = openapi.Parse(context.Background(), []byte(p.OpenAPI))
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidProxy, err)
+		}
+	}
+	forwardScheme := p.ForwardScheme
+	forwardHost := p.ForwardHost + ":" + p.ForwardPort
+	switch p.ForwardScheme {
+	case "h2c":
+		forwardScheme = "http"
+		config.Upstream = transport.NewUpstream("", true)
+	case "unix":
+		// the forward host is the path of the socket, curl names the host
+		// localhost.
+		forwardScheme = "http"
+		forwardHost = "localhost"
+		config.Upstream = transport.NewUpstream(p.ForwardHost, false)
+	}
 	proxy := httputil.NewSingleHostReverseProxy(&url.URL{
 		Scheme: forwardScheme,
-		Host:   forwardHost + ":" + forwardPort,
+		Host:   forwardHost,
 	})
-	switch forwardInsecure {
-	case "on":
+	if p.ForwardInsecure {
 		proxy.Transport = m.InsecureTransport
-	default:
+	} else {
 		proxy.Transport = m.SecureTransport
 	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		logger.Infof(r.Context(), "could not reach %s: %s", p.Forward(), err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+	}
 	proxy.Director = func(r *http.Request) {
 		r.URL.Scheme = forwardScheme
-		r.URL.Host = forwardHost + ":" + forwardPort
-		r.Host = forwardHost + ":" + forwardPort
+		r.URL.Host = forwardHost
+		r.Host = forwardHost
 	}
-	server := http.Server{
-		Addr:    "0.0.0.0:" + listenPort,
-		Handler: proxy,
+	var handler http.Handler = proxy
+	var forward *forwardProxy
+	if p.ForwardScheme == "proxy" {
+		// the clients send the url of the upstream.
+		proxy.Director = func(r *http.Request) {
+			r.Host = r.URL.Host
+		}
+		forward = newForwardProxy(proxy, m.PKIProvider)
+		handler = forward
+	}
+	if len(routes) > 0 {
+		direct := proxy.Director
+		proxy.Director = func(r *http.Request) {
+			if route, ok := routes.match(r); ok {
+				route.direct(r)
+				return
+			}
+			direct(r)
+		}
+		// the h2c or unix socket upstream is the one of the forward address,
+		// routes go to plain http and https hosts.
+		routed := config
+		routed.Upstream = transport.Upstream{}
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, ok := routes.match(r)
+			switch {
+			case ok:
+				r = r.WithContext(transport.WithConfig(r.Context(), routed))
+			case p.ForwardHost == "":
+				http.Error(w, "no route for "+r.Host+r.URL.Path, http.StatusNotFound)
+				return
+			}
+			proxy.ServeHTTP(w, r)
+		})
+	}
+	s := &server{
+		handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler.ServeHTTP(w, r.WithContext(transport.WithConfig(r.Context(), config)))
+		}),
+		forward: forward,
+		status:  Stopped,
 	}
 
-	if listenScheme == "https" {
+	if p.ListenScheme == "https" {
 		csrTemplate := x509.CertificateRequest{
 			Subject: pkix.Name{
 				CommonName:   "localhost",
@@ -307,50 +786,217 @@ func (m *Manager) NewProxy(listenHost, listenPort, listenScheme, forwardHost, fo
 			PrivateKey:  m.PKIProvider.EEPrivateKey(),
 			Certificate: [][]byte{cert},
 		}
-		server.TLSConfig = &tls.Config{
+		s.tlsConfig = &tls.Config{
 			ClientCAs:    m.PKIProvider.CACertPool(),
 			Certificates: []tls.Certificate{tlsCert},
 		}
 	}
 	m.mu.Lock()
-	m.servers[&server] = Running
-	m.proxies = append(m.proxies, Proxy{
-		ListenHost:      listenHost,
-		ListenPort:      listenPort,
-		ListenScheme:    listenScheme,
-		ForwardHost:     forwardHost,
-		ForwardPort:     forwardPort,
-		ForwardScheme:   forwardScheme,
-		ForwardInsecure: forwardInsecure == "on",
-	})
-	m.mu.Unlock()
+	defer m.mu.Unlock()
+	if _, ok := m.servers[p.ID]; ok {
+		return fmt.Errorf("%w: proxy %s already exists", ErrInvalidProxy, p.ID)
+	}
+	stopped := p.Status == Stopped
+	p.Status, p.Error = "", ""
+	m.servers[p.ID] = s
+	m.proxies = append(m.proxies, p)
+	if !stopped {
+		m.start(p, s)
+	}
+	return nil
+}
+
+// server runs a proxy. A stopped http.Server cannot serve again so every
+// start gets a new one.
+type server struct {
+	handler   http.Handler
+	tlsConfig *tls.Config
+	forward   *forwardProxy // nil unless the proxy is a forward proxy
+	http      *http.Server  // nil unless running
+	status    Status
+	err       error
+}
+
+// start listens before returning so that a port that is taken fails the
+// proxy instead of the goroutine. m.mu must be held.
+func (m *Manager) start(p Proxy, s *server) {
+	ctx := context.Background()
+	l, err := net.Listen("tcp", net.JoinHostPort(p.ListenHost, p.ListenPort))
+	if err != nil {
+		logger.Errorf(
+			ctx,
+			"proxy [%s://%s:%s -> %s] could not start: %s",
+			p.ListenScheme, p.ListenHost, p.ListenPort, p.Forward(), err,
+		)
+		s.status, s.err = Failed, err
+		return
+	}
+	httpServer := &http.Server{
+		Handler:   s.handler,
+		TLSConfig: s.tlsConfig,
+	}
+	s.http, s.status, s.err = httpServer, Running, nil
 
 	go func() {
 		logger.Infof(
-			context.Background(),
-			"proxy [%s://%s:%s -> %s://%s:%s] is running",
-			listenScheme, listenHost, listenPort, forwardScheme, forwardHost, forwardPort,
+			ctx,
+			"proxy [%s://%s:%s -> %s] is running",
+			p.ListenScheme, p.ListenHost, p.ListenPort, p.Forward(),
 		)
 		var err error
-		if listenScheme == "https" {
-			err = server.ListenAndServeTLS("", "")
+		if p.ListenScheme == "https" {
+			err = httpServer.ServeTLS(l, "", "")
 		} else {
-			err = server.ListenAndServe()
+			err = httpServer.Serve(l)
 		}
 		if err != nil {
 			logger.Errorf(
-				context.Background(),
-				"proxy [%s://%s:%s -> %s://%s:%s] is stopped: %s",
-				listenScheme, listenHost, listenPort, forwardScheme, forwardHost, forwardPort, err,
+				ctx,
+				"proxy [%s://%s:%s -> %s] is stopped: %s",
+				p.ListenScheme, p.ListenHost, p.ListenPort, p.Forward(), err,
 			)
 		}
 
 		m.mu.Lock()
-		m.servers[&server] = Stopped
-		if err != nil {
-			m.lastError = err
+		// a proxy that was stopped, restarted or deleted already moved on.
+		if s.http == httpServer {
+			s.http, s.status = nil, Stopped
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				s.status, s.err = Failed, err
+			}
 		}
 		m.mu.Unlock()
 	}()
+}
+
+// stop takes the running http.Server away from the proxy and closes its
+// tunnels. m.mu must be held.
+func (m *Manager) stop(id string) (*http.Server, error) {
+	s, ok := m.servers[id]
+	if !ok {
+		return nil, ErrUnknownProxy
+	}
+	if s.forward != nil {
+		s.forward.closeTunnels()
+	}
+	httpServer := s.http
+	s.http, s.status, s.err = nil, Stopped, nil
+	return httpServer, nil
+}
+
+// startError is why the proxy with the given id failed to start, or nil when
+// it did not fail.
+func (m *Manager) startError(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.servers[id]
+	if !ok || s.status != Failed {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrNotStarted, s.err)
+}
+
+func shutdown(ctx context.Context, httpServer *http.Server) error {
+	if httpServer == nil {
+		return nil
+	}
+	return httpServer.Shutdown(ctx)
+}
+
+// StopProxy stops a proxy and keeps it to be restarted.
+func (m *Manager) StopProxy(ctx context.Context, id string) error {
+	m.mu.Lock()
+	httpServer, err := m.stop(id)
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return shutdown(ctx, httpServer)
+}
+
+// RestartProxy stops a proxy if it runs and starts it again. A proxy that
+// cannot start is left Failed with the error.
+func (m *Manager) RestartProxy(ctx context.Context, id string) error {
+	err := m.StopProxy(ctx, id)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.servers[id]
+	if !ok {
+		return ErrUnknownProxy
+	}
+	for _, p := range m.proxies {
+		if p.ID == id && s.http == nil {
+			m.start(p, s)
+		}
+	}
 	return nil
 }
+
+// DeleteProxy stops a proxy and forgets it.
+func (m *Manager) DeleteProxy(ctx context.Context, id string) error {
+	m.mu.Lock()
+	httpServer, err := m.stop(id)
+	if err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	delete(m.servers, id)
+	for i, p := range m.proxies {
+		if p.ID == id {
+			m.proxies = append(m.proxies[:i:i], m.proxies[i+1:]...)
+			break
+		}
+	}
+	m.mu.Unlock()
+	return shutdown(ctx, httpServer)
+}
+
+// UpdateProxy replaces the proxy with the ID of p, in its place in the
+// list. A stopped proxy stays stopped. The old proxy is put back when p is
+// invalid or cannot start, which is an ErrNotStarted.
+func (m *Manager) UpdateProxy(ctx context.Context, p Proxy) error {
+	old, i := Proxy{}, -1
+	for j, existing := range m.Proxies() {
+		if existing.ID == p.ID {
+			old, i = existing, j
+		}
+	}
+	if i < 0 {
+		return ErrUnknownProxy
+	}
+	if old.Status == Stopped {
+		p.Status = Stopped
+	}
+	err := m.DeleteProxy(ctx, p.ID)
+	if err != nil {
+		return err
+	}
+	err = m.NewProxy(p)
+	if err == nil {
+		err = m.startError(p.ID)
+		if err != nil {
+			deleteErr := m.DeleteProxy(ctx, p.ID)
+			if deleteErr != nil {
+				logger.Errorf(ctx, "could not delete proxy %s: %s", p.ID, deleteErr)
+			}
+		}
+	}
+	if err != nil {
+		restoreErr := m.NewProxy(old)
+		if restoreErr != nil {
+			logger.Errorf(ctx, "could not restore proxy %s: %s", old.ID, restoreErr)
+		}
+	}
+	m.mu.Lock()
+	// NewProxy appended the proxy.
+	if last := len(m.proxies) - 1; i < last && m.proxies[last].ID == p.ID {
+		moved := m.proxies[last]
+		copy(m.proxies[i+1:], m.proxies[i:last])
+		m.proxies[i] = moved
+	}
+	m.mu.Unlock()
+	return err
+}
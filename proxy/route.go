@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Route sends the requests for Host under Prefix to Upstream. Host is a
+// glob of the host name, empty for any host. When Upstream has a path it
+// replaces the prefix, so a path of "/" strips it; without one the request
+// path is forwarded as it is.
+type Route struct {
+	Host     string
+	Prefix   string
+	Upstream string
+}
+
+func (r Route) String() string {
+	if r.Host == "" {
+		return r.Prefix + " " + r.Upstream
+	}
+	return r.Host + " " + r.Prefix + " " + r.Upstream
+}
+
+// ParseRoutes reads one route per line written as: [host] prefix upstream.
+// Blank lines and lines starting with # are skipped.
+func ParseRoutes(text string) ([]Route, error) {
+	var routes []Route
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		var route Route
+		switch len(fields) {
+		case 2:
+			route = Route{Prefix: fields[0], Upstream: fields[1]}
+		case 3:
+			route = Route{Host: fields[0], Prefix: fields[1], Upstream: fields[2]}
+		default:
+			return nil, fmt.Errorf("route on line %d should be: [host] prefix upstream", n)
+		}
+		_, err := newRouter([]Route{route})
+		if err != nil {
+			return nil, fmt.Errorf("route on line %d: %w", n, err)
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+func FormatRoutes(routes []Route) string {
+	var lines []string
+	for _, route := range routes {
+		lines = append(lines, route.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+type route struct {
+	Route
+	upstream *url.URL
+}
+
+// router picks the first route of a request.
+type router []route
+
+func newRouter(routes []Route) (router, error) {
+	var result router
+	for _, r := range routes {
+		if !strings.HasPrefix(r.Prefix, "/") {
+			return nil, fmt.Errorf("prefix %q should start with /", r.Prefix)
+		}
+		_, err := path.Match(r.Host, "")
+		if err != nil {
+			return nil, fmt.Errorf("bad host glob %q: %w", r.Host, err)
+		}
+		upstream, err := url.Parse(r.Upstream)
+		if err != nil {
+			return nil, err
+		}
+		if (upstream.Scheme != "http" && upstream.Scheme != "https") || upstream.Host == "" {
+			return nil, fmt.Errorf("upstream %q should be an http or https url", r.Upstream)
+		}
+		result = append(result, route{Route: r, upstream: upstream})
+	}
+	return result, nil
+}
+
+// underPrefix reports whether p is prefix or a path below it.
+func underPrefix(p, prefix string) bool {
+	if !strings.HasPrefix(p, prefix) {
+		return false
+	}
+	return len(p) == len(prefix) || strings.HasSuffix(prefix, "/") || p[len(prefix)] == '/'
+}
+
+func (rt router) match(r *http.Request) (route, bool) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for _, candidate := range rt {
+		if candidate.Host != "" {
+			matched, _ := path.Match(strings.ToLower(candidate.Host), strings.ToLower(host))
+			if !matched {
+				continue
+			}
+		}
+		if underPrefix(r.URL.Path, candidate.Prefix) {
+			return candidate, true
+		}
+	}
+	return route{}, false
+}
+
+// direct points r at the upstream of the route.
+func (rt route) direct(r *http.Request) {
+	if rt.upstream.Path != "" {
+		rest := strings.TrimPrefix(r.URL.Path, rt.Prefix)
+		if rest == "" {
+			r.URL.Path = rt.upstream.Path
+		} else {
+			r.URL.Path = strings.TrimSuffix(rt.upstream.Path, "/") + "/" + strings.TrimPrefix(rest, "/")
+		}
+		r.URL.RawPath = ""
+	}
+	r.URL.Scheme = rt.upstream.Scheme
+	r.URL.Host = rt.upstream.Host
+	r.Host = rt.upstream.Host
+}
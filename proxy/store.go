@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// LoadProxies reads the definitions written by SaveProxies. There are none
+// when the file does not exist.
+func LoadProxies(filename string) ([]ProxyDefinition, error) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var definitions []ProxyDefinition
+	err = json.Unmarshal(data, &definitions)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %q: %w", filename, err)
+	}
+	return definitions, nil
+}
+
+// SaveProxies writes the definitions as a JSON array. The file is replaced
+// in one step so a crash never leaves half of it behind.
+func SaveProxies(filename string, definitions []ProxyDefinition) error {
+	if definitions == nil {
+		definitions = []ProxyDefinition{}
+	}
+	data, err := json.MarshalIndent(definitions, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(append(data, '\n'))
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filename)
+}
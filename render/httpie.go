@@ -0,0 +1,52 @@
+package render
+
+import (
+	"strings"
+
+	"github.com/slcjordan/autodemo"
+	"github.com/slcjordan/autodemo/shell"
+)
+
+type HTTPie struct {
+	Shell shell.Dialect
+}
+
+func (h HTTPie) Name() string         { return "httpie" }
+func (h HTTPie) Lang() string         { return lang(h.Shell) }
+func (h HTTPie) Continuation() string { return h.Shell.Continuation() }
+
+func (h HTTPie) Args(req autodemo.Request) []string {
+	args := []string{"http"}
+	if req.Insecure {
+		args = append(args, "--verify=no")
+	}
+	if req.CookieJar != "" {
+		args = append(args, "--session="+quote(req, h.Shell, strings.TrimSuffix(req.CookieJar, ".txt")+".json"))
+	}
+	switch {
+	case len(req.Form) > 0:
+		args = append(args, "--multipart")
+	case req.BodyFile != "":
+	case req.Body != "":
+		args = append(args, "--raw", quote(req, h.Shell, body(req, h.Shell)))
+	}
+	args = append(args, req.Method, quote(req, h.Shell, req.URL))
+	for _, kv := range headers(req, true) {
+		args = append(args, quote(req, h.Shell, kv.key+":"+kv.value))
+	}
+	for _, part := range req.Form {
+		if part.File != "" {
+			field := part.Name + "@" + part.File
+			if part.ContentType != "" {
+				field += ";type=" + part.ContentType
+			}
+			args = append(args, quote(req, h.Shell, field))
+			continue
+		}
+		args = append(args, quote(req, h.Shell, part.Name+"="+part.Value))
+	}
+	if len(req.Form) == 0 && req.BodyFile != "" {
+		args = append(args, "< "+quote(req, h.Shell, req.BodyFile))
+	}
+	return args
+}
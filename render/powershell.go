@@ -0,0 +1,90 @@
+package render
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/slcjordan/autodemo"
+	"github.com/slcjordan/autodemo/shell"
+)
+
+type PowerShell struct{}
+
+func (PowerShell) Name() string         { return "powershell" }
+func (PowerShell) Lang() string         { return "powershell" }
+func (PowerShell) Continuation() string { return "`\n  " }
+
+func psQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// psQuoteVars reads the variables of the request from the environment.
+func psQuoteVars(req autodemo.Request, s string) string {
+	segments := shell.SplitVars(s, req.Vars)
+	if len(segments) == 0 || (len(segments) == 1 && !segments[0].Var) {
+		return psQuote(s)
+	}
+	var parts []string
+	for _, segment := range segments {
+		if segment.Var {
+			parts = append(parts, "$env:"+segment.Text)
+			continue
+		}
+		parts = append(parts, psQuote(segment.Text))
+	}
+	return "(" + strings.Join(parts, " + ") + ")"
+}
+
+// jarVariable names the web session kept for a cookie jar file.
+func jarVariable(jar string) string {
+	return strings.Map(func(r rune) rune {
+		if r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return r
+		}
+		return '_'
+	}, strings.TrimSuffix(jar, ".txt"))
+}
+
+func (PowerShell) Args(req autodemo.Request) []string {
+	args := []string{"Invoke-RestMethod"}
+	if req.Insecure {
+		args = append(args, "-SkipCertificateCheck")
+	}
+	args = append(args, "-Method", req.Method, "-Uri", psQuoteVars(req, req.URL))
+	if req.CookieJar != "" {
+		// the steps of a jar share a session of the PowerShell session.
+		session := "$" + jarVariable(req.CookieJar)
+		args = append(args, "-WebSession", "("+session+" ??= [Microsoft.PowerShell.Commands.WebRequestSession]::new())")
+	}
+	var pairs []string
+	for _, h := range headers(req, true) {
+		if strings.EqualFold(h.key, "Content-Type") {
+			continue
+		}
+		pairs = append(pairs, psQuote(h.key)+" = "+psQuoteVars(req, h.value))
+	}
+	if len(pairs) > 0 {
+		args = append(args, "-Headers", "@{ "+strings.Join(pairs, "; ")+" }")
+	}
+	if ct := contentType(req); ct != "" && len(req.Form) == 0 {
+		args = append(args, "-ContentType", psQuote(ct))
+	}
+	switch {
+	case len(req.Form) > 0:
+		var fields []string
+		for _, part := range req.Form {
+			if part.File != "" {
+				fields = append(fields, psQuote(part.Name)+" = Get-Item -Path "+psQuote(part.File))
+				continue
+			}
+			fields = append(fields, psQuote(part.Name)+" = "+psQuoteVars(req, part.Value))
+		}
+		args = append(args, "-Form", "@{ "+strings.Join(fields, "; ")+" }")
+	case req.BodyFile != "":
+		args = append(args, "-InFile", psQuote(req.BodyFile))
+	case req.Body != "":
+		args = append(args, "-Body", psQuoteVars(req, body(req, shell.Bash)))
+	}
+	return args
+}
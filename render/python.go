@@ -0,0 +1,137 @@
+package render
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/slcjordan/autodemo"
+	"github.com/slcjordan/autodemo/shell"
+)
+
+type Python struct {
+	Shell shell.Dialect
+}
+
+func (p Python) Name() string         { return "python" }
+func (p Python) Lang() string         { return "python" }
+func (p Python) Continuation() string { return p.Shell.Continuation() }
+
+// pyQuote writes s as a Python string literal. Go's %q is close but its
+// \x escapes are bytes where Python's are code points.
+func pyQuote(s string) string {
+	var result strings.Builder
+	result.WriteByte('"')
+	for _, r := range strings.ToValidUTF8(s, string(utf8.RuneError)) {
+		switch {
+		case r == '"' || r == '\\':
+			result.WriteByte('\\')
+			result.WriteRune(r)
+		case r == '\n':
+			result.WriteString(`\n`)
+		case r == '\r':
+			result.WriteString(`\r`)
+		case r == '\t':
+			result.WriteString(`\t`)
+		case r < 0x80 && !unicode.IsPrint(r):
+			fmt.Fprintf(&result, `\x%02x`, r)
+		case !unicode.IsPrint(r) && r <= 0xffff:
+			fmt.Fprintf(&result, `\u%04x`, r)
+		case !unicode.IsPrint(r):
+			fmt.Fprintf(&result, `\U%08x`, r)
+		default:
+			result.WriteRune(r)
+		}
+	}
+	result.WriteByte('"')
+	return result.String()
+}
+
+// pyString reads the variables of the request from the environment.
+func pyString(req autodemo.Request, s string) string {
+	segments := shell.SplitVars(s, req.Vars)
+	if len(segments) == 0 {
+		return pyQuote(s)
+	}
+	var parts []string
+	for _, segment := range segments {
+		if segment.Var {
+			parts = append(parts, "os.environ["+pyQuote(segment.Text)+"]")
+			continue
+		}
+		parts = append(parts, pyQuote(segment.Text))
+	}
+	return strings.Join(parts, " + ")
+}
+
+func (p Python) Snippet(req autodemo.Request) string {
+	var script strings.Builder
+	if len(req.Vars) > 0 || req.CookieJar != "" {
+		fmt.Fprintf(&script, "import os\n")
+	}
+	if req.CookieJar != "" {
+		fmt.Fprintf(&script, "import http.cookiejar\n")
+	}
+	fmt.Fprintf(&script, "import requests\n\n")
+	client := "requests"
+	if req.CookieJar != "" {
+		// curl keeps its jars in the same format, so the steps can share
+		// them whatever renders them.
+		fmt.Fprintf(&script, "jar = http.cookiejar.MozillaCookieJar(%s)\n", pyQuote(req.CookieJar))
+		fmt.Fprintf(&script, "if os.path.exists(jar.filename):\n")
+		fmt.Fprintf(&script, "    jar.load(ignore_discard=True, ignore_expires=True)\n")
+		fmt.Fprintf(&script, "session = requests.Session()\n")
+		fmt.Fprintf(&script, "session.cookies = jar\n")
+		client = "session"
+	}
+	fmt.Fprintf(&script, "response = %s.request(\n", client)
+	fmt.Fprintf(&script, "    %s,\n", pyQuote(req.Method))
+	fmt.Fprintf(&script, "    %s,\n", pyString(req, req.URL))
+	hs := headers(req, true)
+	if len(hs) > 0 {
+		fmt.Fprintf(&script, "    headers={\n")
+		for _, h := range hs {
+			fmt.Fprintf(&script, "        %s: %s,\n", pyQuote(h.key), pyString(req, h.value))
+		}
+		fmt.Fprintf(&script, "    },\n")
+	}
+	switch {
+	case len(req.Form) > 0:
+		fmt.Fprintf(&script, "    data={\n")
+		for _, part := range req.Form {
+			if part.File == "" {
+				fmt.Fprintf(&script, "        %s: %s,\n", pyQuote(part.Name), pyString(req, part.Value))
+			}
+		}
+		fmt.Fprintf(&script, "    },\n")
+		fmt.Fprintf(&script, "    files={\n")
+		for _, part := range req.Form {
+			if part.File != "" {
+				fmt.Fprintf(&script, "        %s: (%s, open(%s, \"rb\"), %s),\n", pyQuote(part.Name), pyQuote(part.File), pyQuote(part.File), pyQuote(part.ContentType))
+			}
+		}
+		fmt.Fprintf(&script, "    },\n")
+	case req.BodyFile != "":
+		fmt.Fprintf(&script, "    data=open(%s, \"rb\"),\n", pyQuote(req.BodyFile))
+	case req.Body != "":
+		fmt.Fprintf(&script, "    data=%s,\n", pyString(req, req.Body))
+	}
+	if req.Insecure {
+		fmt.Fprintf(&script, "    verify=False,\n")
+	}
+	fmt.Fprintf(&script, ")\n")
+	if req.CookieJar != "" {
+		fmt.Fprintf(&script, "jar.save(ignore_discard=True, ignore_expires=True)\n")
+	}
+	fmt.Fprintf(&script, "print(response.status_code, response.reason)\n")
+	fmt.Fprintf(&script, "print(response.text)\n")
+	return script.String()
+}
+
+func (p Python) Args(req autodemo.Request) []string {
+	if p.Shell == shell.Cmd {
+		return []string{"python", "-c", shell.Quote(p.Shell, p.Snippet(req))}
+	}
+	return []string{"python3 - <<'EOF'\n" + p.Snippet(req) + "EOF"}
+}
@@ -0,0 +1,281 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/slcjordan/autodemo"
+	"github.com/slcjordan/autodemo/shell"
+)
+
+type Renderer interface {
+	Name() string
+	Lang() string
+	Continuation() string
+	Args(req autodemo.Request) []string
+}
+
+// Snippeter is implemented by renderers whose markdown snippet differs from
+// the command typed into the terminal.
+type Snippeter interface {
+	Snippet(req autodemo.Request) string
+}
+
+var renderers = map[string]func(shell.Dialect) Renderer{
+	"curl":       func(d shell.Dialect) Renderer { return Curl{Shell: d} },
+	"httpie":     func(d shell.Dialect) Renderer { return HTTPie{Shell: d} },
+	"wget":       func(d shell.Dialect) Renderer { return Wget{Shell: d} },
+	"powershell": func(d shell.Dialect) Renderer { return PowerShell{} },
+	"python":     func(d shell.Dialect) Renderer { return Python{Shell: d} },
+}
+
+func Lookup(name string, d shell.Dialect) (Renderer, bool) {
+	if name == "" {
+		name = "curl"
+	}
+	if d == "" {
+		d = shell.Bash
+	}
+	newRenderer, ok := renderers[name]
+	if !ok {
+		return nil, false
+	}
+	return newRenderer(d), true
+}
+
+func Names() []string {
+	var names []string
+	for name := range renderers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SaveBody adds to args what it takes to also save the response body to
+// filename. It reports false for renderers that do not print the body
+// alone.
+func SaveBody(r Renderer, d shell.Dialect, args []string, filename string) ([]string, bool) {
+	switch r.(type) {
+	case Curl, HTTPie, Wget:
+	default:
+		return args, false
+	}
+	quoted := shell.Quote(d, filename)
+	if d == shell.Cmd {
+		return append(args, "> "+quoted+" & type "+quoted), true
+	}
+	return append(args, "| tee "+quoted), true
+}
+
+// Expresses reports whether the command of r sends req the way it was
+// recorded. Only curl speaks HTTP over a unix socket or h2c, and wget cannot
+// build a multipart body.
+func Expresses(r Renderer, req autodemo.Request) bool {
+	switch r.(type) {
+	case Curl:
+		return true
+	case Wget:
+		if len(req.Form) > 0 {
+			return false
+		}
+	}
+	return req.UnixSocket == "" && !req.H2C
+}
+
+// Poll wraps the args of a request in a loop that repeats it until the
+// response reached the terminal state of the poll, or in watch when it only
+// waits for the response to change. It reports false for renderers and
+// shells that cannot loop.
+func Poll(r Renderer, d shell.Dialect, args []string, poll autodemo.Poll) ([]string, bool) {
+	switch r.(type) {
+	case Curl, HTTPie, Wget:
+	default:
+		return args, false
+	}
+	if d == shell.Cmd {
+		return args, false
+	}
+	interval := strconv.Itoa(max(1, int(math.Round(poll.Interval.Seconds()))))
+	if poll.Until == "" {
+		// watch runs the command with sh -c, pipes included.
+		return []string{"watch -g -n " + interval, shell.Quote(d, strings.Join(args, " "))}, true
+	}
+	loop := append([]string{"until " + args[0]}, args[1:]...)
+	return append(loop, fmt.Sprintf("| jq -e %s > /dev/null; do sleep %s; done", shell.Quote(d, poll.Until), interval)), true
+}
+
+// Extraction is typed to set a variable from a saved response. The variable
+// is exported so that the alternatives in other languages, which read it
+// from the environment, see it too.
+func Extraction(d shell.Dialect, v autodemo.Variable) string {
+	if d == shell.Cmd {
+		return fmt.Sprintf(`for /f "delims=" %%i in ('jq -r %s %s') do @set %s=%%i`, shell.Quote(d, v.Path), shell.Quote(d, v.File), v.Name)
+	}
+	return fmt.Sprintf("export %s=$(jq -r %s %s)", v.Name, shell.Quote(d, v.Path), shell.Quote(d, v.File))
+}
+
+func IsFlag(arg string) bool {
+	if len(arg) == 0 {
+		return false
+	}
+	return arg[0] == '-'
+}
+
+// BreakBefore reports whether a line continuation should be typed before
+// args[i]: every flag starts a new line and so does every value that does
+// not belong to a flag.
+func BreakBefore(args []string, i int) bool {
+	if i == 0 {
+		return false
+	}
+	return IsFlag(args[i]) || !IsFlag(args[i-1])
+}
+
+func Snippet(r Renderer, req autodemo.Request) string {
+	if s, ok := r.(Snippeter); ok {
+		return s.Snippet(req)
+	}
+	args := r.Args(req)
+	var result strings.Builder
+	for i, arg := range args {
+		if i > 0 {
+			result.WriteString(" ")
+			if BreakBefore(args, i) {
+				result.WriteString(r.Continuation())
+			}
+		}
+		result.WriteString(arg)
+	}
+	return result.String()
+}
+
+func MaybePrettify(uglyJSON string) string {
+	var prettyJSON bytes.Buffer
+	err := json.Indent(&prettyJSON, []byte(uglyJSON), "", "  ")
+	if err != nil {
+		return uglyJSON
+	}
+	return prettyJSON.String()
+}
+
+func contentType(req autodemo.Request) string {
+	for key, values := range req.Header {
+		if strings.EqualFold(key, "Content-Type") && len(values) > 0 {
+			mediaType, _, _ := mime.ParseMediaType(values[0])
+			return mediaType
+		}
+	}
+	return ""
+}
+
+// IsJSON reports whether a content type, parameters included, is JSON.
+func IsJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+func body(req autodemo.Request, d shell.Dialect) string {
+	if IsJSON(contentType(req)) && d != shell.Cmd {
+		return MaybePrettify(req.Body)
+	}
+	return req.Body
+}
+
+type header struct {
+	key   string
+	value string
+}
+
+// headers sorts the request headers. Renderers that build multipart bodies
+// themselves skip Content-Type so the client can pick the boundary.
+func headers(req autodemo.Request, multipart bool) []header {
+	var keys []string
+	for key := range req.Header {
+		if multipart && len(req.Form) > 0 && strings.EqualFold(key, "Content-Type") {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var result []header
+	for _, key := range keys {
+		for _, value := range req.Header[key] {
+			result = append(result, header{key: key, value: value})
+		}
+	}
+	return result
+}
+
+// quote leaves the variables of the request for the shell to expand.
+func quote(req autodemo.Request, d shell.Dialect, s string) string {
+	return shell.QuoteVars(d, s, req.Vars)
+}
+
+func lang(d shell.Dialect) string {
+	if d == shell.Cmd {
+		return "bat"
+	}
+	return "bash"
+}
+
+type Curl struct {
+	Shell shell.Dialect
+}
+
+func (c Curl) Name() string         { return "curl" }
+func (c Curl) Lang() string         { return lang(c.Shell) }
+func (c Curl) Continuation() string { return c.Shell.Continuation() }
+
+func (c Curl) Args(req autodemo.Request) []string {
+	args := []string{"curl"}
+	if req.Insecure {
+		args = append(args, "--insecure")
+	}
+	if req.UnixSocket != "" {
+		args = append(args, "--unix-socket", quote(req, c.Shell, req.UnixSocket))
+	}
+	if req.H2C {
+		args = append(args, "--http2-prior-knowledge")
+	}
+	args = append(args, "-X", req.Method)
+	for _, h := range headers(req, true) {
+		args = append(args, "-H", quote(req, c.Shell, h.key+": "+h.value))
+	}
+	switch {
+	case len(req.Form) > 0:
+		for _, part := range req.Form {
+			switch {
+			case part.File != "":
+				field := part.Name + "=@" + part.File
+				if part.ContentType != "" {
+					field += ";type=" + part.ContentType
+				}
+				args = append(args, "-F", quote(req, c.Shell, field))
+			case strings.HasPrefix(part.Value, "@") || strings.HasPrefix(part.Value, "<"):
+				args = append(args, "--form-string", quote(req, c.Shell, part.Name+"="+part.Value))
+			default:
+				args = append(args, "-F", quote(req, c.Shell, part.Name+"="+part.Value))
+			}
+		}
+	case req.BodyFile != "":
+		args = append(args, "--data-binary", quote(req, c.Shell, "@"+req.BodyFile))
+	case req.Body != "":
+		args = append(args, "--data", quote(req, c.Shell, body(req, c.Shell)))
+	}
+	args = append(args, quote(req, c.Shell, req.URL))
+	if req.CookieJar != "" {
+		jar := quote(req, c.Shell, req.CookieJar)
+		args = append(args, "--cookie", jar, "--cookie-jar", jar)
+	}
+	return args
+}
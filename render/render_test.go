@@ -0,0 +1,235 @@
+package render
+
+import (
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/slcjordan/autodemo"
+	"github.com/slcjordan/autodemo/shell"
+)
+
+// A renderer that is not refused a form upload has to send every part of it.
+func TestExpressesForm(t *testing.T) {
+	req := autodemo.Request{
+		Method: "POST",
+		URL:    "https://api.example.com/photos",
+		Header: map[string][]string{"Content-Type": {"multipart/form-data; boundary=xyz"}},
+		Form: []autodemo.FormPart{
+			{Name: "title", Value: "sunset"},
+			{Name: "photo", File: "upload-000-photo.png", ContentType: "image/png"},
+		},
+	}
+	for _, name := range Names() {
+		for _, d := range shell.Dialects() {
+			r, _ := Lookup(name, d)
+			if !Expresses(r, req) {
+				continue
+			}
+			snippet := Snippet(r, req)
+			for _, want := range []string{"title", "sunset", "photo", "upload-000-photo.png"} {
+				if !strings.Contains(snippet, want) {
+					t.Errorf("%s (%s) leaves %q out of the form:\n%s", name, d, want, snippet)
+				}
+			}
+		}
+	}
+}
+
+// The Python and PowerShell alternatives read extracted variables from the
+// environment, so a pasted extraction has to put them there.
+func TestExtractionExports(t *testing.T) {
+	v := autodemo.Variable{Name: "ORDER_ID", File: "response-001.json", Path: ".id"}
+	for _, d := range []shell.Dialect{shell.Bash, shell.Zsh} {
+		line := Extraction(d, v)
+		if !strings.HasPrefix(line, "export ORDER_ID=") {
+			t.Errorf("%s: %s does not export ORDER_ID", d, line)
+		}
+	}
+	if line := Extraction(shell.Cmd, v); !strings.Contains(line, "set ORDER_ID=") {
+		t.Errorf("cmd: %s does not set ORDER_ID", line)
+	}
+}
+
+// parsedCurl is what curl would send for its args.
+type parsedCurl struct {
+	Method  string
+	URL     string
+	Headers []string
+	Body    string
+	Form    []string
+	Jar     string
+}
+
+func parseCurl(t *testing.T, words []string) parsedCurl {
+	t.Helper()
+	if len(words) == 0 || words[0] != "curl" {
+		t.Fatalf("not a curl command: %q", words)
+	}
+	var p parsedCurl
+	for i := 1; i < len(words); i++ {
+		value := func() string {
+			i++
+			if i >= len(words) {
+				t.Fatalf("%s has no value in %q", words[i-1], words)
+			}
+			return words[i]
+		}
+		switch words[i] {
+		case "--insecure", "--http2-prior-knowledge":
+		case "--unix-socket":
+			value()
+		case "-X":
+			p.Method = value()
+		case "-H":
+			p.Headers = append(p.Headers, value())
+		case "--data", "--data-binary":
+			p.Body = value()
+		case "-F", "--form-string":
+			flag := words[i]
+			p.Form = append(p.Form, flag+" "+value())
+		case "--cookie", "--cookie-jar":
+			p.Jar = value()
+		default:
+			if IsFlag(words[i]) {
+				t.Fatalf("unknown flag %s in %q", words[i], words)
+			}
+			p.URL = words[i]
+		}
+	}
+	return p
+}
+
+var roundTripRequests = []autodemo.Request{
+	{
+		Method: "POST",
+		URL:    "https://api.example.com/notes?q=it's&price=$5",
+		Header: map[string][]string{
+			"Content-Type": {"text/plain"},
+			"X-Note":       {`it's a "test" of \back\slashes, $HOME and 100% ^carets!`},
+		},
+		Body: "it's $5 \"quoted\" \\n `id` !! %PATH% ^ & | < >\nsecond line\ttab héllo",
+	},
+	{
+		Method: "PUT",
+		URL:    "https://api.example.com/orders/$ORDER_ID",
+		Vars:   []string{"ORDER_ID", "TOKEN"},
+		Header: map[string][]string{
+			"Authorization": {"Bearer $TOKEN"},
+			"Content-Type":  {"application/json"},
+		},
+		Body:      `{"note": "it's $TOKEN's order", "path": "C:\\temp"}`,
+		CookieJar: "jar-1.txt",
+	},
+	{
+		Method: "POST",
+		URL:    "https://api.example.com/upload",
+		Header: map[string][]string{"Content-Type": {"multipart/form-data; boundary=xyz"}},
+		Form: []autodemo.FormPart{
+			{Name: "title", Value: "it's \"mine\""},
+			{Name: "handle", Value: "@not-a-file"},
+			{Name: "photo", File: "upload-000-photo.png", ContentType: "image/png"},
+		},
+	},
+	{
+		Method:   "POST",
+		URL:      "https://api.example.com/blobs",
+		Header:   map[string][]string{"Content-Type": {"application/octet-stream"}},
+		BodyFile: "body-000.bin",
+	},
+}
+
+// wantCurl is what parseCurl should find for req. expand writes the
+// variable references the way they should come out.
+func wantCurl(req autodemo.Request, d shell.Dialect, expand func(name string) string) parsedCurl {
+	sub := func(s string) string {
+		var result strings.Builder
+		for _, segment := range shell.SplitVars(s, req.Vars) {
+			if segment.Var {
+				result.WriteString(expand(segment.Text))
+				continue
+			}
+			result.WriteString(segment.Text)
+		}
+		return result.String()
+	}
+	want := parsedCurl{Method: req.Method, URL: sub(req.URL), Jar: req.CookieJar}
+	for _, h := range headers(req, true) {
+		want.Headers = append(want.Headers, sub(h.key+": "+h.value))
+	}
+	switch {
+	case len(req.Form) > 0:
+		for _, part := range req.Form {
+			switch {
+			case part.File != "":
+				want.Form = append(want.Form, "-F "+part.Name+"=@"+part.File+";type="+part.ContentType)
+			case strings.HasPrefix(part.Value, "@"):
+				want.Form = append(want.Form, "--form-string "+part.Name+"="+part.Value)
+			default:
+				want.Form = append(want.Form, "-F "+part.Name+"="+part.Value)
+			}
+		}
+	case req.BodyFile != "":
+		want.Body = "@" + req.BodyFile
+	default:
+		want.Body = sub(body(req, d))
+	}
+	return want
+}
+
+// Split does not expand, so the references come back as written.
+func TestCurlArgsSplit(t *testing.T) {
+	for _, d := range shell.Dialects() {
+		for _, req := range roundTripRequests {
+			args := Curl{Shell: d}.Args(req)
+			words, err := shell.Split(d, Snippet(Curl{Shell: d}, req))
+			if err != nil {
+				t.Errorf("%s: %s: %s", d, req.URL, err)
+				continue
+			}
+			got := parseCurl(t, words)
+			want := wantCurl(req, d, func(name string) string {
+				if d == shell.Cmd {
+					return "%" + name + "%"
+				}
+				return "$" + name
+			})
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: %s\nargs: %q\ngot:  %+v\nwant: %+v", d, req.URL, args, got, want)
+			}
+		}
+	}
+}
+
+// A real shell is the parser that matters for the typed commands. The
+// variables are expanded from the environment.
+func TestCurlArgsShell(t *testing.T) {
+	for _, d := range []shell.Dialect{shell.Bash, shell.Zsh} {
+		path, err := exec.LookPath(string(d))
+		if err != nil {
+			t.Logf("%s is not installed", d)
+			continue
+		}
+		env := map[string]string{"ORDER_ID": "it's 42", "TOKEN": `a"b$c\d`}
+		for _, req := range roundTripRequests {
+			snippet := Snippet(Curl{Shell: d}, req)
+			script := "set -- " + strings.TrimPrefix(snippet, "curl") + "\nprintf '%s\\0' curl \"$@\"\n"
+			cmd := exec.Command(path, "-c", script)
+			for name, val := range env {
+				cmd.Env = append(cmd.Env, name+"="+val)
+			}
+			out, err := cmd.Output()
+			if err != nil {
+				t.Errorf("%s: %s: %s\n%s", d, req.URL, err, snippet)
+				continue
+			}
+			words := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+			got := parseCurl(t, words)
+			want := wantCurl(req, d, func(name string) string { return env[name] })
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: %s\nsnippet: %s\ngot:  %+v\nwant: %+v", d, req.URL, snippet, got, want)
+			}
+		}
+	}
+}
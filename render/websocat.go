@@ -0,0 +1,49 @@
+package render
+
+import (
+	"strings"
+
+	"github.com/slcjordan/autodemo"
+	"github.com/slcjordan/autodemo/shell"
+)
+
+// Websocat renders WebSocket steps whatever the client of the project is,
+// so it is not one of the renderers to choose from.
+type Websocat struct {
+	Shell shell.Dialect
+}
+
+func (w Websocat) Name() string         { return "websocat" }
+func (w Websocat) Lang() string         { return lang(w.Shell) }
+func (w Websocat) Continuation() string { return w.Shell.Continuation() }
+
+// handshakeHeaders are sent by websocat itself.
+var handshakeHeaders = map[string]bool{
+	"upgrade":                  true,
+	"connection":               true,
+	"sec-websocket-key":        true,
+	"sec-websocket-version":    true,
+	"sec-websocket-extensions": true,
+}
+
+func (w Websocat) Args(req autodemo.Request) []string {
+	args := []string{"websocat"}
+	if req.Insecure {
+		args = append(args, "--insecure")
+	}
+	for _, h := range headers(req, false) {
+		if handshakeHeaders[strings.ToLower(h.key)] {
+			continue
+		}
+		args = append(args, "-H", quote(req, w.Shell, h.key+": "+h.value))
+	}
+	url := req.URL
+	switch {
+	case strings.HasPrefix(url, "https://"):
+		url = "wss://" + strings.TrimPrefix(url, "https://")
+	case strings.HasPrefix(url, "http://"):
+		url = "ws://" + strings.TrimPrefix(url, "http://")
+	}
+	args = append(args, quote(req, w.Shell, url))
+	return args
+}
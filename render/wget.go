@@ -0,0 +1,37 @@
+package render
+
+import (
+	"github.com/slcjordan/autodemo"
+	"github.com/slcjordan/autodemo/shell"
+)
+
+type Wget struct {
+	Shell shell.Dialect
+}
+
+func (w Wget) Name() string         { return "wget" }
+func (w Wget) Lang() string         { return lang(w.Shell) }
+func (w Wget) Continuation() string { return w.Shell.Continuation() }
+
+func (w Wget) Args(req autodemo.Request) []string {
+	args := []string{"wget", "-qO-", "--server-response"}
+	if req.Insecure {
+		args = append(args, "--no-check-certificate")
+	}
+	args = append(args, "--method="+req.Method)
+	for _, h := range headers(req, false) {
+		args = append(args, "--header="+quote(req, w.Shell, h.key+": "+h.value))
+	}
+	switch {
+	case req.BodyFile != "":
+		args = append(args, "--body-file="+quote(req, w.Shell, req.BodyFile))
+	case req.Body != "":
+		args = append(args, "--body-data="+quote(req, w.Shell, body(req, w.Shell)))
+	}
+	if req.CookieJar != "" {
+		jar := quote(req, w.Shell, req.CookieJar)
+		args = append(args, "--load-cookies="+jar, "--save-cookies="+jar, "--keep-session-cookies")
+	}
+	args = append(args, quote(req, w.Shell, req.URL))
+	return args
+}
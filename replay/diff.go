@@ -0,0 +1,292 @@
+package replay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type IgnoreKind string
+
+const (
+	IgnoreHeader IgnoreKind = "header"
+	IgnoreJSON   IgnoreKind = "json"
+)
+
+// IgnoreRule hides a difference that is expected to change between runs.
+// Match is a header name glob for header rules and a dotted path for json
+// rules where * matches one key or index and ** matches any depth.
+type IgnoreRule struct {
+	Kind  IgnoreKind
+	Match string
+}
+
+func DefaultIgnoreRules() []IgnoreRule {
+	return []IgnoreRule{
+		{Kind: IgnoreHeader, Match: "Date"},
+		{Kind: IgnoreHeader, Match: "Expires"},
+		{Kind: IgnoreHeader, Match: "Last-Modified"},
+		{Kind: IgnoreHeader, Match: "Etag"},
+		{Kind: IgnoreHeader, Match: "Set-Cookie"},
+		{Kind: IgnoreHeader, Match: "Content-Length"},
+		{Kind: IgnoreHeader, Match: "X-Request-Id"},
+		{Kind: IgnoreHeader, Match: "*-Trace-*"},
+		{Kind: IgnoreJSON, Match: "**.created_at"},
+		{Kind: IgnoreJSON, Match: "**.updated_at"},
+		{Kind: IgnoreJSON, Match: "**.createdAt"},
+		{Kind: IgnoreJSON, Match: "**.updatedAt"},
+		{Kind: IgnoreJSON, Match: "**.timestamp"},
+		{Kind: IgnoreJSON, Match: "**.serial"},
+		{Kind: IgnoreJSON, Match: "**.request_id"},
+	}
+}
+
+// LoadIgnoreRules reads a JSON array of rules. The default rules are used
+// when the file does not exist.
+func LoadIgnoreRules(filename string) ([]IgnoreRule, error) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return DefaultIgnoreRules(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rules []IgnoreRule
+	err = json.Unmarshal(data, &rules)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %q: %w", filename, err)
+	}
+	for i, rule := range rules {
+		switch rule.Kind {
+		case IgnoreHeader, IgnoreJSON:
+		default:
+			return nil, fmt.Errorf("unknown ignore rule kind in rule %d: %q", i, rule.Kind)
+		}
+	}
+	return rules, nil
+}
+
+type Difference struct {
+	Path     string
+	Expected string
+	Actual   string
+}
+
+func matchPath(pattern []string, p []string) bool {
+	if len(pattern) == 0 {
+		return len(p) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(p); i++ {
+			if matchPath(pattern[1:], p[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(p) == 0 {
+		return false
+	}
+	if pattern[0] != "*" && pattern[0] != p[0] {
+		return false
+	}
+	return matchPath(pattern[1:], p[1:])
+}
+
+func splitPattern(match string) []string {
+	return strings.Split(strings.TrimPrefix(strings.TrimPrefix(match, "$"), "."), ".")
+}
+
+// placeholder matches the values that were redacted when recording.
+var placeholder = regexp.MustCompile(`^\$[A-Z][A-Z0-9_]*$`)
+
+type differ struct {
+	rules []IgnoreRule
+	// changed rewrites the values that are expected to change between runs,
+	// such as extracted variables, to what they changed to.
+	changed *strings.Replacer
+	diffs   []Difference
+}
+
+func newDiffer(rules []IgnoreRule, changed map[string]string) *differ {
+	olds := make([]string, 0, len(changed))
+	for old := range changed {
+		olds = append(olds, old)
+	}
+	sort.Slice(olds, func(i, j int) bool {
+		return len(olds[i]) > len(olds[j])
+	})
+	var oldnew []string
+	for _, old := range olds {
+		oldnew = append(oldnew, old, changed[old])
+	}
+	return &differ{
+		rules:   rules,
+		changed: strings.NewReplacer(oldnew...),
+	}
+}
+
+func (d *differ) ignored(kind IgnoreKind, p []string) bool {
+	return Ignored(d.rules, kind, p)
+}
+
+// Ignored reports whether a rule hides the header named p[0] or the JSON
+// value at path p.
+func Ignored(rules []IgnoreRule, kind IgnoreKind, p []string) bool {
+	for _, rule := range rules {
+		if rule.Kind != kind {
+			continue
+		}
+		switch kind {
+		case IgnoreHeader:
+			matched, _ := path.Match(strings.ToLower(rule.Match), strings.ToLower(p[0]))
+			if matched {
+				return true
+			}
+		case IgnoreJSON:
+			if matchPath(splitPattern(rule.Match), p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (d *differ) add(p []string, expected any, actual any) {
+	d.diffs = append(d.diffs, Difference{
+		Path:     strings.Join(p, "."),
+		Expected: describe(expected),
+		Actual:   describe(actual),
+	})
+}
+
+func describe(val any) string {
+	if val == nil {
+		return "(missing)"
+	}
+	data, err := json.Marshal(val)
+	if err != nil {
+		return fmt.Sprint(val)
+	}
+	return string(data)
+}
+
+func (d *differ) scalar(expected string, actual string) bool {
+	if expected == actual || placeholder.MatchString(expected) {
+		return true
+	}
+	return d.changed.Replace(expected) == actual
+}
+
+// json compares two documents. Paths start with body when reported but
+// ignore rules match them from the root of the document.
+func (d *differ) json(p []string, expected any, actual any) {
+	if d.ignored(IgnoreJSON, p[1:]) {
+		return
+	}
+	switch e := expected.(type) {
+	case map[string]any:
+		a, ok := actual.(map[string]any)
+		if !ok {
+			d.add(p, expected, actual)
+			return
+		}
+		keys := make(map[string]bool)
+		for key := range e {
+			keys[key] = true
+		}
+		for key := range a {
+			keys[key] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for key := range keys {
+			sorted = append(sorted, key)
+		}
+		sort.Strings(sorted)
+		for _, key := range sorted {
+			d.json(append(p[:len(p):len(p)], key), e[key], a[key])
+		}
+	case []any:
+		a, ok := actual.([]any)
+		if !ok {
+			d.add(p, expected, actual)
+			return
+		}
+		for i := 0; i < max(len(e), len(a)); i++ {
+			var ev, av any
+			if i < len(e) {
+				ev = e[i]
+			}
+			if i < len(a) {
+				av = a[i]
+			}
+			d.json(append(p[:len(p):len(p)], strconv.Itoa(i)), ev, av)
+		}
+	case string:
+		a, ok := actual.(string)
+		if !ok || !d.scalar(e, a) {
+			d.add(p, expected, actual)
+		}
+	case json.Number:
+		a, ok := actual.(json.Number)
+		if !ok || !d.scalar(e.String(), a.String()) {
+			d.add(p, expected, actual)
+		}
+	default:
+		if describe(expected) != describe(actual) {
+			d.add(p, expected, actual)
+		}
+	}
+}
+
+func decodeJSON(body string) (any, bool) {
+	var doc any
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.UseNumber()
+	if dec.Decode(&doc) != nil {
+		return nil, false
+	}
+	return doc, true
+}
+
+// header compares the recorded headers only. Headers the upstream has
+// started to send are not differences.
+func (d *differ) header(expected map[string][]string, actual map[string][]string) {
+	keys := make([]string, 0, len(expected))
+	for key := range expected {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if d.ignored(IgnoreHeader, []string{key}) {
+			continue
+		}
+		e := strings.Join(expected[key], ", ")
+		values, ok := actual[key]
+		if !ok {
+			d.add([]string{"header", key}, e, nil)
+			continue
+		}
+		if a := strings.Join(values, ", "); !d.scalar(e, a) {
+			d.add([]string{"header", key}, e, a)
+		}
+	}
+}
+
+func (d *differ) body(expected string, actual string) {
+	e, eok := decodeJSON(expected)
+	a, aok := decodeJSON(actual)
+	if eok && aok {
+		d.json([]string{"body"}, e, a)
+		return
+	}
+	if !d.scalar(expected, actual) {
+		d.add([]string{"body"}, expected, actual)
+	}
+}
@@ -0,0 +1,427 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/slcjordan/autodemo"
+	"github.com/slcjordan/autodemo/extract"
+	"github.com/slcjordan/autodemo/shell"
+)
+
+type Options struct {
+	// BaseURL replaces the scheme and host of the recorded requests and
+	// prefixes their paths. The recorded urls are used when it is empty.
+	BaseURL string
+	Ignore  []IgnoreRule
+	// Env looks up the variables that were redacted when recording. It
+	// defaults to os.Getenv.
+	Env func(string) string
+	// Transport defaults to a clone of http.DefaultTransport.
+	Transport *http.Transport
+}
+
+type Step struct {
+	Index          int
+	Method         string
+	URL            string
+	ExpectedStatus int
+	ActualStatus   int
+	Diffs          []Difference
+	Error          string
+}
+
+func (s Step) Passed() bool {
+	return s.Error == "" && len(s.Diffs) == 0
+}
+
+type Report struct {
+	Project  string
+	BaseURL  string
+	Started  time.Time
+	Duration time.Duration
+	Steps    []Step
+}
+
+func (r Report) Passed() bool {
+	for _, step := range r.Steps {
+		if !step.Passed() {
+			return false
+		}
+	}
+	return true
+}
+
+func (r Report) Failed() int {
+	var failed int
+	for _, step := range r.Steps {
+		if !step.Passed() {
+			failed++
+		}
+	}
+	return failed
+}
+
+type replayer struct {
+	opts Options
+	jars map[string]http.CookieJar
+	vars map[string]string
+	// changed maps the recorded values of the variables to the replayed
+	// ones since later responses often echo them.
+	changed map[string]string
+	// missing has the variables that could not be extracted, with the
+	// index of the step they should have come from.
+	missing map[string]int
+	secure  *http.Transport
+	unsafe  *http.Transport
+}
+
+// Run re-issues the recorded requests in order and compares what comes back
+// with what was recorded. Variables extracted from earlier responses are
+// read from the replayed responses instead.
+func Run(ctx context.Context, project string, histories []autodemo.History, opts Options) Report {
+	if opts.Env == nil {
+		opts.Env = os.Getenv
+	}
+	secure := opts.Transport
+	if secure == nil {
+		secure = http.DefaultTransport.(*http.Transport).Clone()
+	}
+	unsafe := secure.Clone()
+	if unsafe.TLSClientConfig == nil {
+		unsafe.TLSClientConfig = new(tls.Config)
+	}
+	unsafe.TLSClientConfig.InsecureSkipVerify = true
+	r := replayer{
+		opts:    opts,
+		jars:    make(map[string]http.CookieJar),
+		vars:    make(map[string]string),
+		changed: make(map[string]string),
+		missing: make(map[string]int),
+		secure:  secure,
+		unsafe:  unsafe,
+	}
+	report := Report{
+		Project: project,
+		BaseURL: opts.BaseURL,
+		Started: time.Now(),
+	}
+	for _, h := range histories {
+		if h.Request.WebSocket || h.Failure != "" {
+			// the messages of a session are not replayed and there is no
+			// response to compare a failed request with.
+			continue
+		}
+		step := r.step(ctx, h)
+		if h.Poll != nil {
+			// a poll is repeated until the response looks like the last one
+			// that was recorded.
+			for attempt := 1; !step.Passed() && step.Error == "" && attempt < 2*h.Poll.Count && ctx.Err() == nil; attempt++ {
+				select {
+				case <-ctx.Done():
+				case <-time.After(max(h.Poll.Interval, time.Second)):
+					step = r.step(ctx, h)
+				}
+			}
+		}
+		report.Steps = append(report.Steps, step)
+	}
+	report.Duration = time.Since(report.Started)
+	return report
+}
+
+func (r *replayer) step(ctx context.Context, h autodemo.History) Step {
+	step := Step{
+		Index:          h.Index,
+		Method:         h.Request.Method,
+		URL:            h.Request.URL,
+		ExpectedStatus: h.Response.Status,
+	}
+	for _, name := range h.Request.Vars {
+		if index, ok := r.missing[name]; ok {
+			// sending the request without the variable would only fail
+			// in a less obvious way.
+			step.Error = fmt.Sprintf("skipped: %s was not extracted in step %d", name, index)
+			return step
+		}
+	}
+	step.URL = r.expand(h.Request.Vars, h.Request.URL)
+	req, err := r.request(ctx, h)
+	if err != nil {
+		step.Error = err.Error()
+		return step
+	}
+	step.URL = req.URL.String()
+	client := http.Client{
+		Transport: r.secure,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	if h.Request.Insecure {
+		client.Transport = r.unsafe
+	}
+	if h.Request.CookieJar != "" {
+		client.Jar = r.jar(h.Request.CookieJar)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		step.Error = err.Error()
+		return step
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		step.Error = err.Error()
+		return step
+	}
+	step.ActualStatus = resp.StatusCode
+
+	rules := r.opts.Ignore[:len(r.opts.Ignore):len(r.opts.Ignore)]
+	for _, v := range h.Extract {
+		val, err := lookup(body, v.Path)
+		if err != nil {
+			step.Error = fmt.Sprintf("could not extract %s: %s", v.Name, err)
+			delete(r.vars, v.Name)
+			delete(r.changed, v.Value)
+			r.missing[v.Name] = h.Index
+			continue
+		}
+		delete(r.missing, v.Name)
+		r.vars[v.Name] = val
+		r.changed[v.Value] = val
+		// the value is expected to change, later steps see the new one.
+		rules = append(rules, IgnoreRule{Kind: IgnoreJSON, Match: jsonPath(v.Path)})
+	}
+	d := newDiffer(rules, r.changed)
+	if step.ExpectedStatus != step.ActualStatus {
+		d.add([]string{"status"}, step.ExpectedStatus, step.ActualStatus)
+	}
+	d.header(h.Response.Header, resp.Header)
+	if h.Response.Body != "" {
+		d.body(h.Response.Body, string(body))
+	}
+	step.Diffs = d.diffs
+	return step
+}
+
+func (r *replayer) jar(name string) http.CookieJar {
+	jar, ok := r.jars[name]
+	if !ok {
+		jar, _ = cookiejar.New(nil)
+		r.jars[name] = jar
+	}
+	return jar
+}
+
+// expand substitutes the $NAME references of a recorded request with the
+// extracted values, or the environment for redacted values.
+func (r *replayer) expand(vars []string, s string) string {
+	var result strings.Builder
+	for _, segment := range shell.SplitVars(s, vars) {
+		if !segment.Var {
+			result.WriteString(segment.Text)
+			continue
+		}
+		name := strings.Trim(segment.Text, "${}")
+		val, ok := r.vars[name]
+		if !ok {
+			val = r.opts.Env(name)
+		}
+		result.WriteString(val)
+	}
+	return result.String()
+}
+
+func (r *replayer) rebase(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if r.opts.BaseURL == "" {
+		return u, nil
+	}
+	base, err := url.Parse(r.opts.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse base url: %w", err)
+	}
+	u.Scheme = base.Scheme
+	u.Host = base.Host
+	u.Path = strings.TrimSuffix(base.Path, "/") + u.Path
+	u.RawPath = ""
+	return u, nil
+}
+
+// skipHeaders are set by the client itself when replaying.
+var skipHeaders = map[string]bool{
+	"Host":            true,
+	"Content-Length":  true,
+	"Accept-Encoding": true,
+	"X-Forwarded-For": true,
+	"Connection":      true,
+}
+
+// Headers returns the recorded headers, hidden ones included, that should be
+// sent again when a request is replayed. Cookies are left to the cookie jar
+// and multipart forms get a new boundary.
+func Headers(req autodemo.Request) map[string][]string {
+	result := make(map[string][]string)
+	for _, header := range []map[string][]string{req.Hidden, req.Header} {
+		for key, values := range header {
+			key = http.CanonicalHeaderKey(key)
+			switch {
+			case skipHeaders[key]:
+			case key == "Cookie" && req.CookieJar != "":
+			case key == "Content-Type" && len(req.Form) > 0:
+			default:
+				result[key] = values
+			}
+		}
+	}
+	return result
+}
+
+func (r *replayer) request(ctx context.Context, h autodemo.History) (*http.Request, error) {
+	u, err := r.rebase(r.expand(h.Request.Vars, h.Request.URL))
+	if err != nil {
+		return nil, err
+	}
+	body, contentType, err := r.body(h)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, h.Request.Method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range Headers(h.Request) {
+		for _, val := range values {
+			req.Header.Add(key, r.expand(h.Request.Vars, val))
+		}
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return req, nil
+}
+
+func artifact(h autodemo.History, name string) ([]byte, error) {
+	for _, a := range h.Artifacts {
+		if a.Name == name {
+			return a.Data, nil
+		}
+	}
+	return nil, fmt.Errorf("missing artifact %q", name)
+}
+
+// body rebuilds the request body. Multipart forms get a new boundary so the
+// content type is returned with them.
+func (r *replayer) body(h autodemo.History) (io.Reader, string, error) {
+	switch {
+	case len(h.Request.Form) > 0:
+		var buf bytes.Buffer
+		w := multipart.NewWriter(&buf)
+		for _, part := range h.Request.Form {
+			if part.File == "" {
+				err := w.WriteField(part.Name, r.expand(h.Request.Vars, part.Value))
+				if err != nil {
+					return nil, "", err
+				}
+				continue
+			}
+			data, err := artifact(h, part.File)
+			if err != nil {
+				return nil, "", err
+			}
+			header := make(textproto.MIMEHeader)
+			header.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, part.Name, part.File))
+			if part.ContentType != "" {
+				header.Set("Content-Type", part.ContentType)
+			}
+			pw, err := w.CreatePart(header)
+			if err != nil {
+				return nil, "", err
+			}
+			_, err = pw.Write(data)
+			if err != nil {
+				return nil, "", err
+			}
+		}
+		err := w.Close()
+		if err != nil {
+			return nil, "", err
+		}
+		return &buf, w.FormDataContentType(), nil
+	case h.Request.BodyFile != "":
+		data, err := artifact(h, h.Request.BodyFile)
+		if err != nil {
+			return nil, "", err
+		}
+		return bytes.NewReader(data), "", nil
+	case h.Request.Body != "":
+		return strings.NewReader(r.expand(h.Request.Vars, h.Request.Body)), "", nil
+	}
+	return nil, "", nil
+}
+
+// jsonPath turns a jq filter into the dotted path of ignore rules.
+func jsonPath(filter string) string {
+	segments, _ := extract.Segments(filter)
+	p := make([]string, len(segments))
+	for i, segment := range segments {
+		p[i] = fmt.Sprint(segment)
+	}
+	return strings.Join(p, ".")
+}
+
+// lookup evaluates a jq filter of the extract package on a JSON body.
+func lookup(body []byte, filter string) (string, error) {
+	segments, err := extract.Segments(filter)
+	if err != nil {
+		return "", err
+	}
+	doc, ok := decodeJSON(string(body))
+	if !ok {
+		return "", fmt.Errorf("response is not JSON")
+	}
+	for _, segment := range segments {
+		switch v := doc.(type) {
+		case map[string]any:
+			key, ok := segment.(string)
+			if !ok {
+				return "", fmt.Errorf("%s: not an array", filter)
+			}
+			doc = v[key]
+		case []any:
+			index, ok := segment.(int)
+			if !ok || index < 0 || index >= len(v) {
+				return "", fmt.Errorf("%s: no such index", filter)
+			}
+			doc = v[index]
+		default:
+			return "", fmt.Errorf("%s: not found", filter)
+		}
+	}
+	switch v := doc.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case nil:
+		return "", fmt.Errorf("%s: not found", filter)
+	}
+	data, err := json.Marshal(doc)
+	return string(data), err
+}
@@ -0,0 +1,67 @@
+package replay
+
+import (
+	"encoding/json"
+	"html/template"
+	"os"
+	"path/filepath"
+)
+
+var reportTemplate = template.Must(template.New("replay").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>replay {{ .Project }}</title>
+<style>
+body { font-family: sans-serif; }
+.passed { color: green; }
+.failed { color: firebrick; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ccc; padding: 2px 6px; text-align: left; vertical-align: top; }
+code { white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>replay {{ .Project }}</h1>
+<p>
+{{ if .Passed }}<span class="passed">passed</span>{{ else }}<span class="failed">{{ .Failed }} of {{ len .Steps }} steps failed</span>{{ end }}
+against {{ if .BaseURL }}<code>{{ .BaseURL }}</code>{{ else }}the recorded urls{{ end }}
+on {{ .Started.Format "2006-01-02 15:04:05" }} in {{ .Duration }}
+</p>
+{{ range .Steps }}
+<h2 class="{{ if .Passed }}passed{{ else }}failed{{ end }}">{{ .Index }}. {{ .Method }} <code>{{ .URL }}</code></h2>
+<p>status {{ .ExpectedStatus }} recorded, {{ .ActualStatus }} replayed</p>
+{{ if .Error }}<p class="failed">{{ .Error }}</p>{{ end }}
+{{ if .Diffs }}
+<table>
+<tr><th>path</th><th>recorded</th><th>replayed</th></tr>
+{{ range .Diffs }}<tr><td><code>{{ .Path }}</code></td><td><code>{{ .Expected }}</code></td><td><code>{{ .Actual }}</code></td></tr>
+{{ end }}
+</table>
+{{ end }}
+{{ end }}
+</body>
+</html>
+`))
+
+// Write saves the report as replay.json and replay.html in dir.
+func (r Report) Write(dir string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(filepath.Join(dir, "replay.json"), data, 0644)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(filepath.Join(dir, "replay.html"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = reportTemplate.Execute(f, r)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,329 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type Dialect string
+
+const (
+	Bash Dialect = "bash"
+	Zsh  Dialect = "zsh"
+	Cmd  Dialect = "cmd"
+)
+
+func Dialects() []Dialect {
+	return []Dialect{Bash, Zsh, Cmd}
+}
+
+func Parse(name string) (Dialect, error) {
+	switch Dialect(name) {
+	case "", Bash:
+		return Bash, nil
+	case Zsh:
+		return Zsh, nil
+	case Cmd:
+		return Cmd, nil
+	}
+	return "", fmt.Errorf("unknown shell: %q", name)
+}
+
+// Continuation is typed at the end of a line to continue a command on the
+// next one.
+func (d Dialect) Continuation() string {
+	if d == Cmd {
+		return "^\n  "
+	}
+	return "\\\n  "
+}
+
+func isSafe(r rune) bool {
+	if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+		return true
+	}
+	return strings.ContainsRune("@%+=:,./-_", r)
+}
+
+func safeWord(d Dialect, s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !isSafe(r) {
+			return false
+		}
+		if d == Cmd && r == '%' {
+			return false
+		}
+	}
+	// zsh expands =word to the path of the word command.
+	return d != Zsh || s[0] != '='
+}
+
+// Quote returns s as a single word for the dialect. The word is left alone
+// if it does not need quoting.
+func Quote(d Dialect, s string) string {
+	if safeWord(d, s) {
+		return s
+	}
+	if d == Cmd {
+		return quoteCmd(s)
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// quoteCmd first quotes s the way CommandLineToArgvW expects and then
+// escapes every cmd.exe metacharacter with a caret so that the quotes
+// themselves cannot toggle cmd.exe into interpreting the rest of the line.
+// A literal newline is written as a caret followed by two newlines.
+func quoteCmd(s string) string {
+	var argv strings.Builder
+	argv.WriteByte('"')
+	backslashes := 0
+	for _, r := range s {
+		switch r {
+		case '\\':
+			backslashes++
+			continue
+		case '"':
+			argv.WriteString(strings.Repeat(`\`, 2*backslashes+1))
+		default:
+			argv.WriteString(strings.Repeat(`\`, backslashes))
+		}
+		backslashes = 0
+		argv.WriteRune(r)
+	}
+	argv.WriteString(strings.Repeat(`\`, 2*backslashes))
+	argv.WriteByte('"')
+
+	var result strings.Builder
+	for _, r := range argv.String() {
+		switch r {
+		case '(', ')', '%', '!', '^', '"', '<', '>', '&', '|':
+			result.WriteByte('^')
+		case '\n':
+			result.WriteString("^\n")
+		case '\r':
+			continue
+		}
+		result.WriteRune(r)
+	}
+	return result.String()
+}
+
+// Segment is either literal text or the name of a variable.
+type Segment struct {
+	Text string
+	Var  bool
+}
+
+func isNameRune(r byte) bool {
+	return r == '_' || ('0' <= r && r <= '9') || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z')
+}
+
+// SplitVars finds the references to the named variables in s. A reference
+// is written $NAME or ${NAME}.
+func SplitVars(s string, vars []string) []Segment {
+	var segments []Segment
+	literal := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' {
+			continue
+		}
+		for _, name := range vars {
+			ref := "$" + name
+			if strings.HasPrefix(s[i:], "${"+name+"}") {
+				ref = "${" + name + "}"
+			} else if !strings.HasPrefix(s[i:], ref) || (i+len(ref) < len(s) && isNameRune(s[i+len(ref)])) {
+				continue
+			}
+			if literal < i {
+				segments = append(segments, Segment{Text: s[literal:i]})
+			}
+			segments = append(segments, Segment{Text: name, Var: true})
+			i += len(ref) - 1
+			literal = i + 1
+			break
+		}
+	}
+	if literal < len(s) {
+		segments = append(segments, Segment{Text: s[literal:]})
+	}
+	return segments
+}
+
+// QuoteVars is like Quote but leaves the references to the named variables
+// for the shell to expand.
+func QuoteVars(d Dialect, s string, vars []string) string {
+	segments := SplitVars(s, vars)
+	if len(segments) == 0 || (len(segments) == 1 && !segments[0].Var) {
+		return Quote(d, s)
+	}
+	var result strings.Builder
+	for _, segment := range segments {
+		switch {
+		case !segment.Var:
+			result.WriteString(Quote(d, segment.Text))
+		case d == Cmd:
+			result.WriteString("%" + segment.Text + "%")
+		default:
+			result.WriteString(`"$` + segment.Text + `"`)
+		}
+	}
+	return result.String()
+}
+
+func Join(d Dialect, words ...string) string {
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = Quote(d, w)
+	}
+	return strings.Join(quoted, " ")
+}
+
+var ErrUnterminated = errors.New("unterminated quote")
+
+// Split parses a command line the way the dialect would hand it to a
+// program. Expansions are not performed.
+func Split(d Dialect, line string) ([]string, error) {
+	if d == Cmd {
+		return splitCmd(line)
+	}
+	return splitPOSIX(line)
+}
+
+func splitPOSIX(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '\\':
+			if i+1 >= len(runes) {
+				word.WriteRune(r)
+				inWord = true
+				continue
+			}
+			i++
+			if runes[i] == '\n' {
+				continue
+			}
+			word.WriteRune(runes[i])
+			inWord = true
+		case r == '\'':
+			end := strings.IndexRune(string(runes[i+1:]), '\'')
+			if end < 0 {
+				return nil, ErrUnterminated
+			}
+			quoted := []rune(string(runes[i+1:])[:end])
+			word.WriteString(string(quoted))
+			i += len(quoted) + 1
+			inWord = true
+		case r == '"':
+			inWord = true
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) && strings.ContainsRune("$`\"\\\n", runes[i+1]) {
+					i++
+					if runes[i] == '\n' {
+						continue
+					}
+				}
+				word.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, ErrUnterminated
+			}
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+func splitCmd(line string) ([]string, error) {
+	// cmd.exe pass: remove carets and line continuations.
+	var unescaped strings.Builder
+	runes := []rune(strings.ReplaceAll(line, "\r\n", "\n"))
+	inQuote := false
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+		case r == '^' && !inQuote:
+			if i+1 >= len(runes) {
+				continue
+			}
+			i++
+			if runes[i] == '\n' {
+				if i+1 < len(runes) && runes[i+1] == '\n' {
+					i++
+					unescaped.WriteRune('\n')
+				}
+				continue
+			}
+			unescaped.WriteRune(runes[i])
+			continue
+		}
+		unescaped.WriteRune(r)
+	}
+	if inQuote {
+		return nil, ErrUnterminated
+	}
+
+	// CommandLineToArgvW pass.
+	var words []string
+	var word strings.Builder
+	inWord := false
+	inQuote = false
+	backslashes := 0
+	for _, r := range unescaped.String() {
+		switch {
+		case r == '\\':
+			backslashes++
+			inWord = true
+			continue
+		case r == '"':
+			word.WriteString(strings.Repeat(`\`, backslashes/2))
+			if backslashes%2 == 1 {
+				word.WriteRune('"')
+			} else {
+				inQuote = !inQuote
+			}
+			inWord = true
+		case (r == ' ' || r == '\t' || r == '\n') && !inQuote:
+			word.WriteString(strings.Repeat(`\`, backslashes))
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteString(strings.Repeat(`\`, backslashes))
+			word.WriteRune(r)
+			inWord = true
+		}
+		backslashes = 0
+	}
+	word.WriteString(strings.Repeat(`\`, backslashes))
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,113 @@
+package shell
+
+import (
+	"testing"
+)
+
+var roundTripInputs = []string{
+	"",
+	"plain",
+	"two words",
+	"it's",
+	`say "hi"`,
+	`'"'"'`,
+	"$HOME",
+	"${HOME}",
+	"$(id)",
+	"`id`",
+	"!!",
+	"%PATH%",
+	"100%",
+	"a^b",
+	"^",
+	`back\slash`,
+	`trailing\`,
+	`\"`,
+	`\\"`,
+	"(a) <b> & c | d",
+	"=word",
+	"line one\nline two",
+	"\n",
+	"tab\there",
+	"héllo wörld",
+	"日本語",
+	"emoji 🎉",
+	`{"token": "$abc", "note": "it's 100%!"}`,
+}
+
+func TestQuoteRoundTrip(t *testing.T) {
+	for _, d := range Dialects() {
+		for _, s := range roundTripInputs {
+			quoted := Quote(d, s)
+			words, err := Split(d, quoted)
+			if err != nil {
+				t.Errorf("%s: Split(%q): %s", d, quoted, err)
+				continue
+			}
+			if len(words) != 1 || words[0] != s {
+				t.Errorf("%s: Split(Quote(%q)) = %q, quoted as %q", d, s, words, quoted)
+			}
+		}
+	}
+}
+
+func TestJoinRoundTrip(t *testing.T) {
+	for _, d := range Dialects() {
+		words, err := Split(d, Join(d, roundTripInputs...))
+		if err != nil {
+			t.Errorf("%s: %s", d, err)
+			continue
+		}
+		if len(words) != len(roundTripInputs) {
+			t.Fatalf("%s: got %d words, want %d: %q", d, len(words), len(roundTripInputs), words)
+		}
+		for i, w := range words {
+			if w != roundTripInputs[i] {
+				t.Errorf("%s: word %d = %q, want %q", d, i, w, roundTripInputs[i])
+			}
+		}
+	}
+}
+
+func TestQuoteVars(t *testing.T) {
+	vars := []string{"TOKEN", "ID"}
+	for _, tt := range []struct {
+		d    Dialect
+		in   string
+		want string
+	}{
+		{Bash, "Bearer $TOKEN", `'Bearer '"$TOKEN"`},
+		{Zsh, "Bearer ${TOKEN}", `'Bearer '"$TOKEN"`},
+		{Cmd, "Bearer $TOKEN", `^"Bearer ^"%TOKEN%`},
+		{Bash, "/orders/$ID/items", `/orders/"$ID"/items`},
+		// literal dollars and unknown names stay quoted.
+		{Bash, "costs $5 for $OTHER", `'costs $5 for $OTHER'`},
+		{Bash, "$TOKENS", `'$TOKENS'`},
+		{Bash, "$TOKEN$", `"$TOKEN"'$'`},
+		{Cmd, "100% $ID", `^"100^% ^"%ID%`},
+	} {
+		got := QuoteVars(tt.d, tt.in, vars)
+		if got != tt.want {
+			t.Errorf("%s: QuoteVars(%q) = %s, want %s", tt.d, tt.in, got, tt.want)
+		}
+	}
+}
+
+// Split does not expand, so the references come back as they were written
+// and the literal text around them as it was.
+func TestQuoteVarsSplit(t *testing.T) {
+	vars := []string{"TOKEN"}
+	for _, d := range []Dialect{Bash, Zsh} {
+		for _, s := range []string{"Bearer $TOKEN", "it's $TOKEN and $5", "$TOKEN", "a`b`$TOKEN!"} {
+			quoted := QuoteVars(d, s, vars)
+			words, err := Split(d, quoted)
+			if err != nil {
+				t.Errorf("%s: Split(%q): %s", d, quoted, err)
+				continue
+			}
+			if len(words) != 1 || words[0] != s {
+				t.Errorf("%s: Split(QuoteVars(%q)) = %q, quoted as %s", d, s, words, quoted)
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package transport
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"path/filepath"
+	"regexp"
+
+	"github.com/slcjordan/autodemo"
+	"github.com/slcjordan/autodemo/har"
+)
+
+var unsafeFilename = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func artifactName(index int, filename string) string {
+	return fmt.Sprintf("upload-%03d-%s", index, unsafeFilename.ReplaceAllString(filepath.Base(filename), "_"))
+}
+
+// addArtifact keeps data as a file of the step unless it is over the
+// artifact limit.
+func (c *Curl) addArtifact(h *autodemo.History, name string, data []byte) {
+	if limit := c.Truncate.MaxArtifactBytes; limit > 0 && len(data) > limit {
+		return
+	}
+	h.Artifacts = append(h.Artifacts, autodemo.Artifact{Name: name, Data: data})
+}
+
+func (c *Curl) captureBody(h *autodemo.History, contentType string, body []byte) {
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+	if mediaType == "multipart/form-data" && params["boundary"] != "" {
+		form, err := c.readForm(h, params["boundary"], body)
+		if err == nil {
+			h.Request.Form = form
+		}
+	}
+	if len(h.Request.Form) > 0 || !har.IsText(contentType, body) {
+		h.Request.BodyFile = fmt.Sprintf("body-%03d.bin", h.Index)
+		c.addArtifact(h, h.Request.BodyFile, body)
+		return
+	}
+	h.Request.Body = string(body)
+}
+
+func (c *Curl) readForm(h *autodemo.History, boundary string, body []byte) ([]autodemo.FormPart, error) {
+	var form []autodemo.FormPart
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return form, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+		formPart := autodemo.FormPart{
+			Name:        part.FormName(),
+			ContentType: part.Header.Get("Content-Type"),
+		}
+		if part.FileName() == "" && har.IsText(formPart.ContentType, data) {
+			formPart.Value = string(data)
+			form = append(form, formPart)
+			continue
+		}
+		filename := part.FileName()
+		if filename == "" {
+			filename = formPart.Name + ".bin"
+		}
+		formPart.File = artifactName(h.Index, filename)
+		c.addArtifact(h, formPart.File, data)
+		form = append(form, formPart)
+	}
+}
@@ -0,0 +1,29 @@
+package transport
+
+import (
+	"context"
+
+	"github.com/slcjordan/autodemo/openapi"
+)
+
+// Config is how the proxy a request came through wants it captured.
+// Header policies and an OpenAPI document that are nil fall back to the ones
+// of the Curl transport and so does the zero Upstream.
+type Config struct {
+	Filter          *Filter
+	RequestHeaders  *HeaderPolicy
+	ResponseHeaders *HeaderPolicy
+	OpenAPI         *openapi.Spec
+	Upstream        Upstream
+}
+
+type configKey struct{}
+
+func WithConfig(ctx context.Context, config Config) context.Context {
+	return context.WithValue(ctx, configKey{}, config)
+}
+
+func ConfigFrom(ctx context.Context) Config {
+	config, _ := ctx.Value(configKey{}).(Config)
+	return config
+}
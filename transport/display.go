@@ -0,0 +1,178 @@
+package transport
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/andybalholm/brotli"
+	"github.com/slcjordan/autodemo"
+	"github.com/slcjordan/autodemo/har"
+	"github.com/slcjordan/autodemo/render"
+)
+
+// decodeBody undoes the content codings of a response body so that it can be
+// displayed. Codings are listed in the order they were applied.
+func decodeBody(contentEncoding string, body []byte) ([]byte, error) {
+	codings := strings.Split(contentEncoding, ",")
+	for i := len(codings) - 1; i >= 0; i-- {
+		var r io.Reader
+		var err error
+		switch coding := strings.ToLower(strings.TrimSpace(codings[i])); coding {
+		case "", "identity":
+			continue
+		case "gzip", "x-gzip":
+			r, err = gzip.NewReader(bytes.NewReader(body))
+		case "deflate":
+			// most servers send zlib wrapped deflate but some send it raw.
+			r, err = zlib.NewReader(bytes.NewReader(body))
+			if err != nil {
+				r, err = flate.NewReader(bytes.NewReader(body)), nil
+			}
+		case "br":
+			r = brotli.NewReader(bytes.NewReader(body))
+		default:
+			return nil, fmt.Errorf("unsupported content encoding: %q", coding)
+		}
+		if err != nil {
+			return nil, err
+		}
+		body, err = io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+func byteSize(n int) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := unit, 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// binarySummary replaces a body that would break the terminal.
+func binarySummary(contentType string, body []byte) string {
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return fmt.Sprintf("[binary body omitted: %s, %s]\n", contentType, byteSize(len(body)))
+}
+
+type TruncateStrategy string
+
+const (
+	TruncateNone   TruncateStrategy = "none"
+	TruncateHead   TruncateStrategy = "head"
+	TruncateMiddle TruncateStrategy = "middle"
+)
+
+// Truncation bounds how much of a response body is typed into the terminal.
+// The head strategy keeps the start of the body and the middle strategy
+// keeps the start and the end. Whatever is dropped is replaced by an elision
+// marker. MaxArtifactBytes bounds the uploads and binary bodies kept as
+// files; a larger one is left out and its step cannot be replayed. A zero
+// limit is not enforced.
+type Truncation struct {
+	Strategy         TruncateStrategy
+	MaxLines         int
+	MaxBytes         int
+	MaxArtifactBytes int
+}
+
+func DefaultTruncation() Truncation {
+	return Truncation{
+		Strategy:         TruncateMiddle,
+		MaxLines:         40,
+		MaxBytes:         4096,
+		MaxArtifactBytes: 10 << 20,
+	}
+}
+
+func elided(n int, unit string) string {
+	return fmt.Sprintf("[... %d %s elided ...]", n, unit)
+}
+
+// runeBoundary moves i back to the start of a utf8 sequence.
+func runeBoundary(s string, i int) int {
+	for i > 0 && i < len(s) && !utf8.RuneStart(s[i]) {
+		i--
+	}
+	return i
+}
+
+func (t Truncation) Apply(s string) string {
+	switch t.Strategy {
+	case TruncateHead, TruncateMiddle:
+	default:
+		return s
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if t.MaxLines > 0 && len(lines) > t.MaxLines {
+		head, tail := t.MaxLines, 0
+		if t.Strategy == TruncateMiddle {
+			head, tail = (t.MaxLines+1)/2, t.MaxLines/2
+		}
+		n := len(lines) - head - tail
+		s = strings.Join(lines[:head], "") + elided(n, "lines") + "\n" + strings.Join(lines[len(lines)-tail:], "")
+	}
+	if t.MaxBytes > 0 && len(s) > t.MaxBytes {
+		if t.Strategy == TruncateHead {
+			head := runeBoundary(s, t.MaxBytes)
+			s = s[:head] + "\n" + elided(len(s)-head, "bytes") + "\n"
+		} else {
+			head := runeBoundary(s, (t.MaxBytes+1)/2)
+			tail := runeBoundary(s, len(s)-t.MaxBytes/2)
+			s = s[:head] + "\n" + elided(tail-head, "bytes") + "\n" + s[tail:]
+		}
+	}
+	return s
+}
+
+// displayBody turns a raw response body into what is shown in the terminal.
+func (c *Curl) displayBody(contentType string, contentEncoding string, body []byte) string {
+	decoded, err := decodeBody(contentEncoding, body)
+	if err != nil {
+		return fmt.Sprintf("[%s body could not be decoded: %s]\n", contentEncoding, err)
+	}
+	if !har.IsText(contentType, decoded) {
+		return binarySummary(contentType, decoded)
+	}
+	text := string(decoded)
+	if render.IsJSON(contentType) {
+		text = render.MaybePrettify(text)
+	}
+	return c.Truncate.Apply(text)
+}
+
+// captureResponse keeps the response for passes that run after capture.
+func captureResponse(resp *http.Response, body []byte, policy HeaderPolicy) autodemo.Response {
+	response := autodemo.Response{
+		Status: resp.StatusCode,
+		Header: resp.Header.Clone(),
+	}
+	if !policy.Keep {
+		response.Header, _ = policy.split(response.Header)
+	}
+	decoded, err := decodeBody(resp.Header.Get("Content-Encoding"), body)
+	if err == nil && har.IsText(resp.Header.Get("Content-Type"), decoded) {
+		response.Body = string(decoded)
+	}
+	return response
+}
@@ -0,0 +1,62 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+func port(req *http.Request) string {
+	if port := req.URL.Port(); port != "" {
+		return port
+	}
+	if req.URL.Scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
+
+// curlError writes a request that got no response the way curl reports it,
+// with curl's exit code.
+func curlError(req *http.Request, err error, elapsed time.Duration) string {
+	host := req.URL.Hostname()
+	ms := elapsed.Milliseconds()
+
+	var dnsErr *net.DNSError
+	var hostnameErr x509.HostnameError
+	var authorityErr x509.UnknownAuthorityError
+	var invalidErr x509.CertificateInvalidError
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var netErr net.Error
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &dnsErr):
+		return fmt.Sprintf("curl: (6) Could not resolve host: %s", host)
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()):
+		return fmt.Sprintf("curl: (28) Operation timed out after %d milliseconds with 0 bytes received", ms)
+	case errors.As(err, &hostnameErr):
+		return fmt.Sprintf("curl: (60) SSL: no alternative certificate subject name matches target host name '%s'", host)
+	case errors.As(err, &invalidErr) && invalidErr.Reason == x509.Expired:
+		return "curl: (60) SSL certificate problem: certificate has expired"
+	case errors.As(err, &authorityErr), errors.As(err, &invalidErr), errors.As(err, &certErr):
+		return "curl: (60) SSL certificate problem: unable to get local issuer certificate"
+	case errors.As(err, &recordErr), errors.As(err, &alertErr):
+		return fmt.Sprintf("curl: (35) TLS connect error: %s", err)
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return fmt.Sprintf("curl: (7) Failed to connect to %s port %s after %d ms: Couldn't connect to server", host, port(req), ms)
+	case errors.Is(err, syscall.ECONNRESET):
+		return "curl: (56) Recv failure: Connection reset by peer"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "curl: (52) Empty reply from server"
+	}
+	return fmt.Sprintf("curl: (56) Failure when receiving data from the peer: %s", err)
+}
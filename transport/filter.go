@@ -0,0 +1,186 @@
+package transport
+
+import (
+	"bufio"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type FilterAction string
+
+const (
+	FilterInclude FilterAction = "include"
+	FilterExclude FilterAction = "exclude"
+)
+
+type FilterField string
+
+const (
+	FilterMethod      FilterField = "method"
+	FilterPath        FilterField = "path"
+	FilterHost        FilterField = "host"
+	FilterStatus      FilterField = "status"
+	FilterContentType FilterField = "content_type"
+)
+
+// FilterRule decides whether a round trip becomes a history step. Pattern
+// is a glob where * matches anything, or a regular expression when it
+// starts with ~. Content type is the media type of the response.
+type FilterRule struct {
+	Action  FilterAction
+	Field   FilterField
+	Pattern string
+}
+
+func (r FilterRule) String() string {
+	return fmt.Sprintf("%s %s %s", r.Action, r.Field, r.Pattern)
+}
+
+func DefaultFilterRules() []FilterRule {
+	return []FilterRule{
+		{Action: FilterExclude, Field: FilterMethod, Pattern: "OPTIONS"},
+		{Action: FilterExclude, Field: FilterPath, Pattern: "/favicon.ico"},
+		{Action: FilterExclude, Field: FilterPath, Pattern: `~(^|/)(health|healthz|livez|readyz|ping)$`},
+	}
+}
+
+// ParseFilterRules reads one rule per line written as: action field
+// pattern. Blank lines and lines starting with # are skipped.
+func ParseFilterRules(text string) ([]FilterRule, error) {
+	var rules []FilterRule
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("filter rule on line %d should be: action field pattern", n)
+		}
+		rules = append(rules, FilterRule{
+			Action:  FilterAction(fields[0]),
+			Field:   FilterField(fields[1]),
+			Pattern: fields[2],
+		})
+	}
+	_, err := NewFilter(rules...)
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func FormatFilterRules(rules []FilterRule) string {
+	var lines []string
+	for _, rule := range rules {
+		lines = append(lines, rule.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func globRegexp(glob string) string {
+	var result strings.Builder
+	result.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			result.WriteString(".*")
+		case '?':
+			result.WriteString(".")
+		default:
+			result.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	result.WriteString("$")
+	return result.String()
+}
+
+type Filter struct {
+	rules   []FilterRule
+	regexps []*regexp.Regexp
+}
+
+func NewFilter(rules ...FilterRule) (*Filter, error) {
+	f := Filter{rules: rules}
+	for i, rule := range rules {
+		switch rule.Action {
+		case FilterInclude, FilterExclude:
+		default:
+			return nil, fmt.Errorf("unknown filter action: %q", rule.Action)
+		}
+		switch rule.Field {
+		case FilterMethod, FilterPath, FilterHost, FilterStatus, FilterContentType:
+		default:
+			return nil, fmt.Errorf("unknown filter field: %q", rule.Field)
+		}
+		expr := globRegexp(rule.Pattern)
+		if strings.HasPrefix(rule.Pattern, "~") {
+			expr = strings.TrimPrefix(rule.Pattern, "~")
+		}
+		if rule.Field == FilterMethod || rule.Field == FilterContentType {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("could not compile filter rule %d: %w", i, err)
+		}
+		f.regexps = append(f.regexps, re)
+	}
+	return &f, nil
+}
+
+func (f *Filter) Rules() []FilterRule {
+	if f == nil {
+		return nil
+	}
+	return f.rules
+}
+
+func filterValue(field FilterField, req *http.Request, resp *http.Response) string {
+	switch field {
+	case FilterMethod:
+		return req.Method
+	case FilterPath:
+		return req.URL.Path
+	case FilterHost:
+		return req.URL.Hostname()
+	case FilterStatus:
+		if resp != nil {
+			return strconv.Itoa(resp.StatusCode)
+		}
+	case FilterContentType:
+		if resp != nil {
+			mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+			return mediaType
+		}
+	}
+	return ""
+}
+
+// Allow reports whether a round trip should be recorded. When there are
+// include rules it has to match one of them, and it must not match any
+// exclude rule. A nil filter allows everything.
+func (f *Filter) Allow(req *http.Request, resp *http.Response) bool {
+	if f == nil {
+		return true
+	}
+	included, hasIncludes := false, false
+	for i, rule := range f.rules {
+		matched := f.regexps[i].MatchString(filterValue(rule.Field, req, resp))
+		switch rule.Action {
+		case FilterInclude:
+			hasIncludes = true
+			included = included || matched
+		case FilterExclude:
+			if matched {
+				return false
+			}
+		}
+	}
+	return included || !hasIncludes
+}
@@ -0,0 +1,222 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/slcjordan/autodemo"
+)
+
+// HeaderPolicy decides which headers are shown in the recorded command or
+// output. Show and Hide are header name globs and Show wins over Hide.
+// Keep leaves hidden headers in the structured capture so that they can
+// still be replayed or exported.
+type HeaderPolicy struct {
+	Show []string
+	Hide []string
+	Keep bool
+}
+
+func DefaultRequestHeaders() HeaderPolicy {
+	return HeaderPolicy{
+		Hide: []string{"X-Forwarded-For", "Cookie", "User-Agent", "Accept-Encoding", "Content-Length"},
+	}
+}
+
+func DefaultResponseHeaders() HeaderPolicy {
+	return HeaderPolicy{
+		Hide: []string{
+			"X-Forwarded-For", "User-Agent", "Accept-Encoding", "Content-Length",
+			"Connection", "X-Envoy-Upstream-Service-Time", "Date",
+			"X-Dc-Transaction-Id", "Strict-Transport-Security", "Pragma",
+			"X-Frame-Options", "Cache-Control", "X-Xss-Protection",
+			"X-Content-Type-Options", "Vary", "Expires",
+		},
+		Keep: true,
+	}
+}
+
+// headerPolicies picks the policies of the proxy, then of the transport and
+// then the defaults.
+func (c *Curl) headerPolicies(ctx context.Context) (HeaderPolicy, HeaderPolicy) {
+	request, response := DefaultRequestHeaders(), DefaultResponseHeaders()
+	if c.RequestHeaders != nil {
+		request = *c.RequestHeaders
+	}
+	if c.ResponseHeaders != nil {
+		response = *c.ResponseHeaders
+	}
+	config := ConfigFrom(ctx)
+	if config.RequestHeaders != nil {
+		request = *config.RequestHeaders
+	}
+	if config.ResponseHeaders != nil {
+		response = *config.ResponseHeaders
+	}
+	return request, response
+}
+
+func matchHeader(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		matched, _ := path.Match(strings.ToLower(pattern), strings.ToLower(key))
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (p HeaderPolicy) Visible(key string) bool {
+	return matchHeader(p.Show, key) || !matchHeader(p.Hide, key)
+}
+
+// split sorts headers into the ones to show and the hidden ones to keep.
+func (p HeaderPolicy) split(header map[string][]string) (map[string][]string, map[string][]string) {
+	shown := make(map[string][]string)
+	var hidden map[string][]string
+	for key, values := range header {
+		switch {
+		case p.Visible(key):
+			shown[key] = values
+		case p.Keep:
+			if hidden == nil {
+				hidden = make(map[string][]string)
+			}
+			hidden[key] = values
+		}
+	}
+	return shown, hidden
+}
+
+// CheckHeaderGlobs reports the first header glob that is malformed.
+func CheckHeaderGlobs(globs []string) error {
+	for _, glob := range globs {
+		_, err := path.Match(glob, "")
+		if err != nil {
+			return fmt.Errorf("bad header glob %q: %w", glob, err)
+		}
+	}
+	return nil
+}
+
+// HideHeaders hides more headers of a recorded step, for a project that
+// was recorded through a proxy it shares with others. The request headers
+// move to the hidden ones, so that replays and exports still send them, and
+// the response headers are taken out of the output but kept in the capture.
+func HideHeaders(h autodemo.History, globs []string) autodemo.History {
+	header := make(map[string][]string, len(h.Request.Header))
+	hidden := maps.Clone(h.Request.Hidden)
+	for key, values := range h.Request.Header {
+		if !matchHeader(globs, key) {
+			header[key] = values
+			continue
+		}
+		if hidden == nil {
+			hidden = make(map[string][]string)
+		}
+		hidden[key] = values
+	}
+	h.Request.Header, h.Request.Hidden = header, hidden
+	h.Output = hideOutputHeaders(h.Output, globs)
+	return h
+}
+
+// hideOutputHeaders drops header lines from the head that curlResponseHead
+// wrote. Output that does not start with one, such as a curl error, is left
+// as it is.
+func hideOutputHeaders(output string, globs []string) string {
+	rest := strings.TrimPrefix(output, "\n")
+	head, body, ok := strings.Cut(rest, "\n\n")
+	if !ok || !strings.HasPrefix(head, "HTTP/") {
+		return output
+	}
+	lines := strings.Split(head, "\n")
+	kept := lines[:1]
+	for _, line := range lines[1:] {
+		key, _, _ := strings.Cut(line, ":")
+		if !matchHeader(globs, key) {
+			kept = append(kept, line)
+		}
+	}
+	return output[:len(output)-len(rest)] + strings.Join(kept, "\n") + "\n\n" + body
+}
+
+// ParseHeaderPolicies adds the rules in text to the default policies. Each
+// line is: request|response show|hide header-glob, or request|response keep
+// or drop.
+func ParseHeaderPolicies(text string) (HeaderPolicy, HeaderPolicy, error) {
+	request, response := DefaultRequestHeaders(), DefaultResponseHeaders()
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		var policy *HeaderPolicy
+		switch fields[0] {
+		case "request":
+			policy = &request
+		case "response":
+			policy = &response
+		default:
+			return request, response, fmt.Errorf("header rule on line %d should start with request or response", n)
+		}
+		switch {
+		case len(fields) == 3 && fields[1] == "show":
+			policy.Show = append(policy.Show, fields[2])
+		case len(fields) == 3 && fields[1] == "hide":
+			policy.Hide = append(policy.Hide, fields[2])
+		case len(fields) == 2 && fields[1] == "keep":
+			policy.Keep = true
+		case len(fields) == 2 && fields[1] == "drop":
+			policy.Keep = false
+		default:
+			return request, response, fmt.Errorf("could not parse header rule on line %d: %q", n, line)
+		}
+		for _, pattern := range fields[2:] {
+			_, err := path.Match(pattern, "")
+			if err != nil {
+				return request, response, fmt.Errorf("bad header glob on line %d: %w", n, err)
+			}
+		}
+	}
+	return request, response, nil
+}
+
+// FormatHeaderPolicies writes the rules that ParseHeaderPolicies adds to the
+// defaults to get request and response.
+func FormatHeaderPolicies(request, response HeaderPolicy) string {
+	var lines []string
+	for _, p := range []struct {
+		name     string
+		policy   HeaderPolicy
+		defaults HeaderPolicy
+	}{
+		{"request", request, DefaultRequestHeaders()},
+		{"response", response, DefaultResponseHeaders()},
+	} {
+		for _, pattern := range p.policy.Show {
+			if !slices.Contains(p.defaults.Show, pattern) {
+				lines = append(lines, p.name+" show "+pattern)
+			}
+		}
+		for _, pattern := range p.policy.Hide {
+			if !slices.Contains(p.defaults.Hide, pattern) {
+				lines = append(lines, p.name+" hide "+pattern)
+			}
+		}
+		switch {
+		case p.policy.Keep && !p.defaults.Keep:
+			lines = append(lines, p.name+" keep")
+		case !p.policy.Keep && p.defaults.Keep:
+			lines = append(lines, p.name+" drop")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
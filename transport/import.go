@@ -0,0 +1,186 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/slcjordan/autodemo"
+	"github.com/slcjordan/autodemo/har"
+)
+
+var ErrNoResponse = errors.New("har entry has no response")
+
+func protoFromHAR(version string) (string, int, int) {
+	switch strings.ToLower(version) {
+	case "h2", "http/2", "http/2.0":
+		return "HTTP/2.0", 2, 0
+	case "h3", "http/3", "http/3.0":
+		return "HTTP/3.0", 3, 0
+	}
+	major, minor, ok := http.ParseHTTPVersion(strings.ToUpper(version))
+	if !ok {
+		return "HTTP/1.1", 1, 1
+	}
+	return fmt.Sprintf("HTTP/%d.%d", major, minor), major, minor
+}
+
+func headerFromHAR(values []har.NameValue) http.Header {
+	header := make(http.Header)
+	for _, nv := range values {
+		if strings.HasPrefix(nv.Name, ":") { // http2 pseudo headers
+			continue
+		}
+		header.Add(nv.Name, nv.Value)
+	}
+	return header
+}
+
+// postDataBody is the request body of a HAR entry. Browsers record form
+// bodies as params with no text, so those are encoded again and returned
+// with their Content-Type. The content of uploaded files is not in the
+// archive and they are sent empty.
+func postDataBody(postData har.PostData) ([]byte, string, error) {
+	if postData.Text != "" || len(postData.Params) == 0 {
+		return []byte(postData.Text), "", nil
+	}
+	mediaType, params, _ := mime.ParseMediaType(postData.MimeType)
+	if mediaType != "multipart/form-data" {
+		values := make([]string, len(postData.Params))
+		for i, param := range postData.Params {
+			values[i] = url.QueryEscape(param.Name) + "=" + url.QueryEscape(param.Value)
+		}
+		contentType := postData.MimeType
+		if mediaType != "application/x-www-form-urlencoded" {
+			contentType = "application/x-www-form-urlencoded"
+		}
+		return []byte(strings.Join(values, "&")), contentType, nil
+	}
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	// the recorded boundary keeps the Content-Type header as it was.
+	w.SetBoundary(params["boundary"])
+	for _, param := range postData.Params {
+		if param.FileName == "" {
+			err := w.WriteField(param.Name, param.Value)
+			if err != nil {
+				return nil, "", err
+			}
+			continue
+		}
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, quoteEscaper.Replace(param.Name), quoteEscaper.Replace(param.FileName)))
+		if param.ContentType != "" {
+			header.Set("Content-Type", param.ContentType)
+		}
+		part, err := w.CreatePart(header)
+		if err != nil {
+			return nil, "", err
+		}
+		_, err = io.WriteString(part, param.Value)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	err := w.Close()
+	if err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), w.FormDataContentType(), nil
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// HistoryFromEntry rebuilds the history for an entry of an imported HAR
+// archive as if the request had gone through RoundTrip, redacted and
+// truncated the same way.
+func (c *Curl) HistoryFromEntry(entry har.Entry) (autodemo.History, error) {
+	if entry.Response.Status == 0 {
+		return autodemo.History{}, ErrNoResponse
+	}
+	var body io.Reader
+	var reqBody []byte
+	var contentType string
+	if entry.Request.PostData != nil {
+		var err error
+		reqBody, contentType, err = postDataBody(*entry.Request.PostData)
+		if err != nil {
+			return autodemo.History{}, err
+		}
+		body = bytes.NewReader(reqBody)
+	}
+	req, err := http.NewRequest(entry.Request.Method, entry.Request.URL, body)
+	if err != nil {
+		return autodemo.History{}, err
+	}
+	req.Header = headerFromHAR(entry.Request.Headers)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Proto, req.ProtoMajor, req.ProtoMinor = protoFromHAR(entry.Request.HTTPVersion)
+
+	respBody := []byte(entry.Response.Content.Text)
+	if entry.Response.Content.Encoding == "base64" {
+		respBody, err = base64.StdEncoding.DecodeString(entry.Response.Content.Text)
+		if err != nil {
+			return autodemo.History{}, err
+		}
+	}
+	// the content of a HAR entry is decoded whatever the Content-Encoding
+	// header says.
+	respHeader := headerFromHAR(entry.Response.Headers)
+	respHeader.Del("Content-Encoding")
+	resp := &http.Response{
+		Status:     fmt.Sprintf("%d %s", entry.Response.Status, entry.Response.StatusText),
+		StatusCode: entry.Response.Status,
+		Header:     respHeader,
+		Body:       io.NopCloser(bytes.NewReader(respBody)),
+		Request:    req,
+	}
+	if entry.Response.StatusText == "" {
+		resp.Status = fmt.Sprintf("%d %s", entry.Response.Status, http.StatusText(entry.Response.Status))
+	}
+	resp.Proto, resp.ProtoMajor, resp.ProtoMinor = protoFromHAR(entry.Response.HTTPVersion)
+
+	h := c.CurlFromRequest(req)
+	c.trackCookies(req, resp, &h)
+	h.Output = c.curlResponseFormat(resp)
+	_, policy := c.headerPolicies(req.Context())
+	h.Response = captureResponse(resp, respBody, policy)
+	h.Started = entry.StartedDateTime
+	h.ExecTime = fromMillis(entry.Time)
+	t := entry.Timings
+	h.Latency = autodemo.Latency{
+		DNS:       fromMillis(t.DNS),
+		Connect:   fromMillis(t.Connect),
+		TLS:       fromMillis(t.SSL),
+		FirstByte: fromMillis(t.Total() - max(t.Receive, 0)),
+	}
+	if c.Redactor != nil {
+		c.Redactor.Learn(req.Header, reqBody)
+		c.Redactor.Learn(resp.Header, respBody)
+		for _, artifact := range h.Artifacts {
+			c.Redactor.Learn(nil, artifact.Data)
+		}
+		h = c.Redactor.History(h)
+		h.Args = c.args(h.Request)
+	}
+	return h, nil
+}
+
+// fromMillis converts a HAR timing. Timings that do not apply are zero.
+func fromMillis(ms float64) time.Duration {
+	if ms < 0 {
+		return 0
+	}
+	return time.Duration(ms * float64(time.Millisecond))
+}
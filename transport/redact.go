@@ -0,0 +1,441 @@
+package transport
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/slcjordan/autodemo"
+	"github.com/slcjordan/autodemo/har"
+)
+
+type RedactKind string
+
+const (
+	RedactHeader RedactKind = "header"
+	RedactJSON   RedactKind = "json"
+	RedactRegex  RedactKind = "regex"
+	RedactPEM    RedactKind = "pem"
+)
+
+// RedactRule finds secrets in captured traffic. Match is a header name glob
+// for header rules, a dotted path for json rules (* matches one key or index
+// and ** matches any depth), a regular expression for regex rules (the first
+// capture group is the secret if there is one) and a block type for pem
+// rules. Every secret a rule finds is replaced by $<Placeholder>.
+type RedactRule struct {
+	Kind        RedactKind
+	Match       string
+	Placeholder string
+}
+
+func DefaultRedactRules() []RedactRule {
+	return []RedactRule{
+		{Kind: RedactHeader, Match: "Authorization", Placeholder: "API_KEY"},
+		{Kind: RedactHeader, Match: "Proxy-Authorization", Placeholder: "API_KEY"},
+		{Kind: RedactHeader, Match: "X-Api-Key", Placeholder: "API_KEY"},
+		{Kind: RedactHeader, Match: "*-Token", Placeholder: "TOKEN"},
+		{Kind: RedactHeader, Match: "Cookie", Placeholder: "COOKIE"},
+		{Kind: RedactHeader, Match: "Set-Cookie", Placeholder: "COOKIE"},
+		{Kind: RedactJSON, Match: "**.password", Placeholder: "PASSWORD"},
+		{Kind: RedactJSON, Match: "**.client_secret", Placeholder: "CLIENT_SECRET"},
+		{Kind: RedactJSON, Match: "**.api_key", Placeholder: "API_KEY"},
+		{Kind: RedactJSON, Match: "**.access_token", Placeholder: "ACCESS_TOKEN"},
+		{Kind: RedactJSON, Match: "**.refresh_token", Placeholder: "REFRESH_TOKEN"},
+		{Kind: RedactRegex, Match: `eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`, Placeholder: "JWT"},
+		{Kind: RedactRegex, Match: `AKIA[0-9A-Z]{16}`, Placeholder: "AWS_ACCESS_KEY_ID"},
+		{Kind: RedactRegex, Match: `gh[pousr]_[A-Za-z0-9]{36,}`, Placeholder: "GITHUB_TOKEN"},
+		{Kind: RedactPEM, Match: "PRIVATE KEY", Placeholder: "PRIVATE_KEY"},
+	}
+}
+
+// LoadRedactRules reads a JSON array of rules. The default rules are used
+// when the file does not exist.
+func LoadRedactRules(filename string) ([]RedactRule, error) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return DefaultRedactRules(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rules []RedactRule
+	err = json.Unmarshal(data, &rules)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %q: %w", filename, err)
+	}
+	return rules, nil
+}
+
+// secrets shorter than this would replace too much of the demo.
+const minSecretLen = 4
+
+var pemBlock = regexp.MustCompile(`(?s)-----BEGIN ([A-Z0-9 ]+)-----.*?-----END ([A-Z0-9 ]+)-----`)
+
+type Redactor struct {
+	mu      sync.Mutex // guards secrets, counts
+	secrets map[string]string
+	counts  map[string]int
+
+	rules   []RedactRule
+	regexps map[int]*regexp.Regexp
+}
+
+func NewRedactor(rules ...RedactRule) (*Redactor, error) {
+	regexps, err := compileRedactRules(rules)
+	if err != nil {
+		return nil, err
+	}
+	r := Redactor{
+		rules:   rules,
+		regexps: regexps,
+	}
+	r.Reset()
+	return &r, nil
+}
+
+// ValidateRedactRules reports the first rule that NewRedactor would turn
+// down, for callers that build their redactors later.
+func ValidateRedactRules(rules []RedactRule) error {
+	_, err := compileRedactRules(rules)
+	return err
+}
+
+func compileRedactRules(rules []RedactRule) (map[int]*regexp.Regexp, error) {
+	regexps := make(map[int]*regexp.Regexp)
+	for i, rule := range rules {
+		switch rule.Kind {
+		case RedactHeader, RedactJSON, RedactPEM:
+		case RedactRegex:
+			re, err := regexp.Compile(rule.Match)
+			if err != nil {
+				return nil, fmt.Errorf("could not compile redact rule %d: %w", i, err)
+			}
+			regexps[i] = re
+		default:
+			return nil, fmt.Errorf("unknown redact rule kind: %q", rule.Kind)
+		}
+		if rule.Placeholder == "" {
+			return nil, fmt.Errorf("redact rule %d has no placeholder", i)
+		}
+	}
+	return regexps, nil
+}
+
+// Reset forgets every secret so that placeholders start over for the next
+// project.
+func (r *Redactor) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.secrets = make(map[string]string)
+	r.counts = make(map[string]int)
+}
+
+func (r *Redactor) remember(secret string, placeholder string) {
+	if len(secret) < minSecretLen {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.secrets[secret]; ok {
+		return
+	}
+	r.counts[placeholder]++
+	if n := r.counts[placeholder]; n > 1 {
+		placeholder = fmt.Sprintf("%s_%d", placeholder, n)
+	}
+	r.secrets[secret] = "$" + placeholder
+}
+
+func headerSecrets(key string, value string) []string {
+	switch http.CanonicalHeaderKey(key) {
+	case "Cookie":
+		var result []string
+		for _, c := range (&http.Request{Header: http.Header{"Cookie": {value}}}).Cookies() {
+			result = append(result, c.Value)
+		}
+		return result
+	case "Set-Cookie":
+		c, err := http.ParseSetCookie(value)
+		if err != nil {
+			return nil
+		}
+		return []string{c.Value}
+	}
+	scheme, credentials, found := strings.Cut(value, " ")
+	if found && !strings.Contains(scheme, "=") {
+		return []string{strings.TrimSpace(credentials)}
+	}
+	return []string{value}
+}
+
+func matchPath(pattern []string, p []string) bool {
+	if len(pattern) == 0 {
+		return len(p) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(p); i++ {
+			if matchPath(pattern[1:], p[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(p) == 0 {
+		return false
+	}
+	if pattern[0] != "*" && pattern[0] != p[0] {
+		return false
+	}
+	return matchPath(pattern[1:], p[1:])
+}
+
+func walkJSON(val any, p []string, f func(p []string, s string)) {
+	switch v := val.(type) {
+	case map[string]any:
+		for key, child := range v {
+			walkJSON(child, append(p[:len(p):len(p)], key), f)
+		}
+	case []any:
+		for i, child := range v {
+			walkJSON(child, append(p[:len(p):len(p)], fmt.Sprint(i)), f)
+		}
+	case string:
+		f(p, v)
+	case json.Number:
+		f(p, v.String())
+	}
+}
+
+// Learn finds secrets in a request or response.
+func (r *Redactor) Learn(header http.Header, body []byte) {
+	var doc any
+	dec := json.NewDecoder(strings.NewReader(string(body)))
+	dec.UseNumber()
+	if dec.Decode(&doc) != nil {
+		doc = nil
+	}
+	for i, rule := range r.rules {
+		switch rule.Kind {
+		case RedactHeader:
+			for key, values := range header {
+				matched, _ := path.Match(strings.ToLower(rule.Match), strings.ToLower(key))
+				if !matched {
+					continue
+				}
+				for _, val := range values {
+					for _, secret := range headerSecrets(key, val) {
+						r.remember(secret, rule.Placeholder)
+					}
+				}
+			}
+		case RedactJSON:
+			pattern := strings.Split(strings.TrimPrefix(strings.TrimPrefix(rule.Match, "$"), "."), ".")
+			walkJSON(doc, nil, func(p []string, s string) {
+				if matchPath(pattern, p) {
+					r.remember(s, rule.Placeholder)
+				}
+			})
+		case RedactRegex:
+			texts := []string{string(body)}
+			for _, values := range header {
+				texts = append(texts, values...)
+			}
+			for _, text := range texts {
+				for _, match := range r.regexps[i].FindAllStringSubmatch(text, -1) {
+					r.remember(match[min(1, len(match)-1)], rule.Placeholder)
+				}
+			}
+		case RedactPEM:
+			texts := []string{string(body)}
+			walkJSON(doc, nil, func(_ []string, s string) {
+				texts = append(texts, s)
+			})
+			for _, text := range texts {
+				for _, match := range pemBlock.FindAllStringSubmatch(text, -1) {
+					if strings.Contains(match[1], rule.Match) {
+						r.remember(match[0], rule.Placeholder)
+					}
+				}
+			}
+		}
+	}
+}
+
+func jsonEscaped(s string) string {
+	var buff strings.Builder
+	enc := json.NewEncoder(&buff)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	escaped := strings.TrimSuffix(buff.String(), "\n")
+	return escaped[1 : len(escaped)-1]
+}
+
+// Replace substitutes every learned secret in s with its placeholder.
+// Secrets are also matched in their JSON escaped form.
+func (r *Redactor) Replace(s string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	secrets := make([]string, 0, len(r.secrets))
+	for secret := range r.secrets {
+		secrets = append(secrets, secret)
+	}
+	sort.Slice(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+	var oldnew []string
+	for _, secret := range secrets {
+		oldnew = append(oldnew, secret, r.secrets[secret])
+		if escaped := jsonEscaped(secret); escaped != secret {
+			oldnew = append(oldnew, escaped, r.secrets[secret])
+		}
+	}
+	return strings.NewReplacer(oldnew...).Replace(s)
+}
+
+// History redacts the structured request and the output. Callers should
+// render the args again from the redacted request. Artifacts are redacted
+// as bytes, so a binary upload only changes when a secret is in it, and a
+// leaked secret is worse than a broken upload.
+func (r *Redactor) History(h autodemo.History) autodemo.History {
+	h.Output = r.Replace(h.Output)
+	h.Request.URL = r.Replace(h.Request.URL)
+	h.Request.Body = r.Replace(h.Request.Body)
+	h.Request.Header = r.header(h.Request.Header)
+	h.Request.Hidden = r.header(h.Request.Hidden)
+	form := make([]autodemo.FormPart, len(h.Request.Form))
+	for i, part := range h.Request.Form {
+		form[i] = part
+		form[i].Value = r.Replace(part.Value)
+	}
+	h.Request.Form = form
+	h.Response.Body = r.Replace(h.Response.Body)
+	h.Response.Header = r.header(h.Response.Header)
+	chunks := make([]autodemo.Chunk, len(h.Chunks))
+	for i, chunk := range h.Chunks {
+		chunks[i] = autodemo.Chunk{Offset: chunk.Offset, Data: r.Replace(chunk.Data)}
+	}
+	h.Chunks = chunks
+	messages := make([]autodemo.Message, len(h.Messages))
+	for i, message := range h.Messages {
+		messages[i] = message
+		messages[i].Data = r.Replace(message.Data)
+	}
+	h.Messages = messages
+	problems := make([]string, len(h.Operation.Problems))
+	for i, problem := range h.Operation.Problems {
+		problems[i] = r.Replace(problem)
+	}
+	h.Operation.Problems = problems
+	h.Failure = r.Replace(h.Failure)
+	artifacts := make([]autodemo.Artifact, len(h.Artifacts))
+	for i, artifact := range h.Artifacts {
+		artifacts[i] = autodemo.Artifact{Name: artifact.Name, Data: []byte(r.Replace(string(artifact.Data)))}
+	}
+	h.Artifacts = artifacts
+	h.Request.Vars = r.placeholders(h.Request)
+	return h
+}
+
+func (r *Redactor) header(header map[string][]string) map[string][]string {
+	if header == nil {
+		return nil
+	}
+	result := make(map[string][]string, len(header))
+	for key, values := range header {
+		for _, val := range values {
+			result[key] = append(result[key], r.Replace(val))
+		}
+	}
+	return result
+}
+
+// placeholders lists the placeholders used by a redacted request so that
+// the shell expands them.
+func (r *Redactor) placeholders(req autodemo.Request) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	texts := []string{req.URL, req.Body}
+	for _, values := range req.Header {
+		texts = append(texts, values...)
+	}
+	for _, part := range req.Form {
+		texts = append(texts, part.Value)
+	}
+	text := strings.Join(texts, "\n")
+	vars := req.Vars
+	for _, placeholder := range r.secrets {
+		name := strings.TrimPrefix(placeholder, "$")
+		if strings.Contains(text, placeholder) && !slices.Contains(vars, name) {
+			vars = append(vars, name)
+		}
+	}
+	sort.Strings(vars)
+	return vars
+}
+
+func (r *Redactor) nameValues(nvs []har.NameValue) []har.NameValue {
+	result := make([]har.NameValue, len(nvs))
+	for i, nv := range nvs {
+		result[i] = har.NameValue{Name: nv.Name, Value: r.Replace(nv.Value)}
+	}
+	return result
+}
+
+func (r *Redactor) cookies(cs []har.Cookie) []har.Cookie {
+	result := make([]har.Cookie, len(cs))
+	for i, c := range cs {
+		result[i] = c
+		result[i].Value = r.Replace(c.Value)
+	}
+	return result
+}
+
+func (r *Redactor) Entry(e har.Entry) har.Entry {
+	e.Request.URL = r.Replace(e.Request.URL)
+	e.Request.Headers = r.nameValues(e.Request.Headers)
+	e.Request.QueryString = r.nameValues(e.Request.QueryString)
+	e.Request.Cookies = r.cookies(e.Request.Cookies)
+	if e.Request.PostData != nil {
+		postData := *e.Request.PostData
+		postData.Text = r.Replace(postData.Text)
+		params := make([]har.Param, len(postData.Params))
+		for i, param := range postData.Params {
+			params[i] = param
+			params[i].Value = r.Replace(param.Value)
+		}
+		postData.Params = params
+		e.Request.PostData = &postData
+	}
+	e.Response.Headers = r.nameValues(e.Response.Headers)
+	e.Response.Cookies = r.cookies(e.Response.Cookies)
+	e.Response.Content.Text = r.content(e.Response.Content.Text, e.Response.Content.Encoding)
+	return e
+}
+
+// content redacts the text of a HAR body. Base64 text is decoded first so
+// that secrets in it are found; text that does not decode is left out
+// rather than kept unredacted.
+func (r *Redactor) content(text, encoding string) string {
+	if encoding != "base64" {
+		return r.Replace(text)
+	}
+	data, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString([]byte(r.Replace(string(data))))
+}
@@ -0,0 +1,120 @@
+package transport
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/slcjordan/autodemo"
+)
+
+// streamSeparator reports whether a response is a stream of events and what
+// ends each event in it. Bodies without a separator are chunked as they are
+// read.
+func streamSeparator(resp *http.Response) (string, bool) {
+	if resp == nil {
+		return "", false
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/event-stream":
+		return "\n\n", true
+	case "application/x-ndjson", "application/jsonl", "application/stream+json":
+		return "\n", true
+	case "multipart/x-mixed-replace":
+		return "", true
+	}
+	return "", false
+}
+
+// streamRecorder passes a body through as it arrives and, when split is
+// set, records when each chunk did. done is called once when the body ends
+// or is closed.
+type streamRecorder struct {
+	body    io.ReadCloser
+	started time.Time
+	sep     []byte
+	split   bool
+
+	mu      sync.Mutex // guards buff, pending, chunks
+	buff    bytes.Buffer
+	pending int
+	chunks  []autodemo.Chunk
+
+	once sync.Once
+	done func(body []byte, chunks []autodemo.Chunk)
+}
+
+func newStreamRecorder(body io.ReadCloser, sep string, split bool, done func([]byte, []autodemo.Chunk)) *streamRecorder {
+	return &streamRecorder{
+		body:    body,
+		started: time.Now(),
+		sep:     []byte(sep),
+		split:   split,
+		done:    done,
+	}
+}
+
+func (s *streamRecorder) chunk(end int) {
+	data := s.buff.Bytes()[s.pending:end]
+	s.chunks = append(s.chunks, autodemo.Chunk{
+		Offset: time.Since(s.started),
+		Data:   string(data),
+	})
+	s.pending = end
+}
+
+func (s *streamRecorder) record(p []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.buff.Write(p)
+	if !s.split {
+		return
+	}
+	if len(s.sep) == 0 {
+		s.chunk(s.buff.Len())
+		return
+	}
+	for {
+		i := bytes.Index(s.buff.Bytes()[s.pending:], s.sep)
+		if i < 0 {
+			return
+		}
+		s.chunk(s.pending + i + len(s.sep))
+	}
+}
+
+func (s *streamRecorder) finish() {
+	s.once.Do(func() {
+		s.mu.Lock()
+		if s.split && s.pending < s.buff.Len() {
+			s.chunk(s.buff.Len())
+		}
+		body := bytes.Clone(s.buff.Bytes())
+		chunks := s.chunks
+		s.mu.Unlock()
+
+		s.done(body, chunks)
+	})
+}
+
+func (s *streamRecorder) Read(p []byte) (int, error) {
+	n, err := s.body.Read(p)
+	if n > 0 {
+		s.record(p[:n])
+	}
+	if err != nil {
+		s.finish()
+	}
+	return n, err
+}
+
+func (s *streamRecorder) Close() error {
+	err := s.body.Close()
+	s.finish()
+	return err
+}
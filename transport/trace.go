@@ -0,0 +1,116 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/slcjordan/autodemo"
+	"github.com/slcjordan/autodemo/har"
+)
+
+// tracer records when each phase of a round trip started and finished.
+type tracer struct {
+	mu                     sync.Mutex // guards all fields
+	started                time.Time
+	gotConn                time.Time
+	dnsStart, dnsDone      time.Time
+	connectStart, connDone time.Time
+	tlsStart, tlsDone      time.Time
+	wroteRequest           time.Time
+	firstByte              time.Time
+}
+
+func (t *tracer) mark(at *time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	*at = time.Now()
+}
+
+func newTracer(ctx context.Context, started time.Time) (context.Context, *tracer) {
+	t := &tracer{started: started}
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn:              func(httptrace.GotConnInfo) { t.mark(&t.gotConn) },
+		DNSStart:             func(httptrace.DNSStartInfo) { t.mark(&t.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { t.mark(&t.dnsDone) },
+		ConnectStart:         func(string, string) { t.mark(&t.connectStart) },
+		ConnectDone:          func(string, string, error) { t.mark(&t.connDone) },
+		TLSHandshakeStart:    func() { t.mark(&t.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { t.mark(&t.tlsDone) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { t.mark(&t.wroteRequest) },
+		GotFirstResponseByte: func() { t.mark(&t.firstByte) },
+	}), t
+}
+
+func between(start time.Time, end time.Time) time.Duration {
+	if start.IsZero() || end.IsZero() {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+func (t *tracer) Latency() autodemo.Latency {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return autodemo.Latency{
+		DNS:       between(t.dnsStart, t.dnsDone),
+		Connect:   between(t.connectStart, t.connDone),
+		TLS:       between(t.tlsStart, t.tlsDone),
+		FirstByte: between(t.started, t.firstByte),
+	}
+}
+
+// harMillis is -1 for a phase that did not happen.
+func harMillis(start time.Time, end time.Time) float64 {
+	if start.IsZero() || end.IsZero() {
+		return -1
+	}
+	return har.Millis(end.Sub(start))
+}
+
+// Timings converts the trace into HAR timings. HAR counts the TLS handshake
+// as part of connect as well as reporting it separately.
+func (t *tracer) Timings(finished time.Time) har.Timings {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	timings := har.Timings{
+		Blocked: -1,
+		DNS:     harMillis(t.dnsStart, t.dnsDone),
+		Connect: harMillis(t.connectStart, t.connDone),
+		SSL:     harMillis(t.tlsStart, t.tlsDone),
+		Send:    harMillis(t.gotConn, t.wroteRequest),
+		Wait:    harMillis(t.wroteRequest, t.firstByte),
+		Receive: harMillis(t.firstByte, finished),
+	}
+	if timings.Connect >= 0 && timings.SSL >= 0 && t.tlsDone.After(t.connDone) {
+		timings.Connect += timings.SSL
+	}
+	if first := firstOf(t.dnsStart, t.connectStart, t.gotConn); !first.IsZero() {
+		timings.Blocked = har.Millis(first.Sub(t.started))
+	}
+	if timings.Wait < 0 {
+		timings.Wait = har.Millis(between(t.started, finished))
+	}
+	if timings.Send < 0 {
+		timings.Send = 0
+	}
+	if timings.Receive < 0 {
+		timings.Receive = 0
+	}
+	return timings
+}
+
+func firstOf(times ...time.Time) time.Time {
+	var first time.Time
+	for _, at := range times {
+		if !at.IsZero() && (first.IsZero() || at.Before(first)) {
+			first = at
+		}
+	}
+	return first
+}
@@ -2,25 +2,34 @@ package transport
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/cookiejar"
-	"net/http/httputil"
 	"net/url"
 	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/slcjordan/autodemo"
+	"github.com/slcjordan/autodemo/har"
+	"github.com/slcjordan/autodemo/openapi"
+	"github.com/slcjordan/autodemo/render"
+	"github.com/slcjordan/autodemo/shell"
 )
 
 type HistoryListener interface {
 	Notify(autodemo.History)
 }
 
+type EntryListener interface {
+	NotifyEntry(har.Entry)
+}
+
 type Curl struct {
 	mu    sync.RWMutex //guards jars, count
 	jars  []http.CookieJar
@@ -28,7 +37,15 @@ type Curl struct {
 
 	Transport http.RoundTripper
 	Listener  HistoryListener
+	Archive   EntryListener
+	Redactor  *Redactor
 	Insecure  bool
+	Shell     shell.Dialect
+	Truncate  Truncation
+
+	RequestHeaders  *HeaderPolicy
+	ResponseHeaders *HeaderPolicy
+	OpenAPI         *openapi.Spec
 }
 
 func (c *Curl) Reset() {
@@ -61,54 +78,40 @@ func (c *Curl) findMatchingJar(cookies []*http.Cookie, u *url.URL) (int, bool) {
 	return 0, false
 }
 
-func maybePrettify(uglyJSON string) string {
-	var prettyJSON bytes.Buffer
-	err := json.Indent(&prettyJSON, []byte(uglyJSON), "", "  ")
-	if err != nil {
-		return uglyJSON
-	}
-	return prettyJSON.String()
-}
-
 func (c *Curl) CurlFromRequest(req *http.Request) autodemo.History {
 	var h autodemo.History
-	h.Args = append(h.Args, "curl")
-	if c.Insecure {
-		h.Args = append(h.Args, "--insecure")
-	}
-	h.Args = append(h.Args, "-X", req.Method)
-
-	for key, values := range req.Header {
-		switch key {
-		case "X-Forwarded-For", "Cookie", "User-Agent", "Accept-Encoding", "Content-Length":
-			continue
-		default:
-		}
-		for _, val := range values {
-			h.Args = append(h.Args, "-H", fmt.Sprintf("\"%s: %s\"", key, val))
-		}
+	policy, _ := c.headerPolicies(req.Context())
+	h.Request = autodemo.Request{
+		Method:   req.Method,
+		URL:      req.URL.String(),
+		Insecure: c.Insecure,
 	}
-
-	if req.Body != nil && (req.Method == "POST" || req.Method == "PUT" || req.Method == "PATCH") {
-		body, err := httputil.DumpRequest(req, true)
-		if err == nil {
-			bodyStr := string(body)
-			parts := strings.SplitN(bodyStr, "\r\n\r\n", 2)
-			if len(parts) > 1 {
-				if req.Header.Get("Content-Type") == "application/json" {
-					h.Args = append(h.Args, "--data", fmt.Sprintf("'%s'", maybePrettify(parts[1])))
-				} else {
-					h.Args = append(h.Args, "--data", fmt.Sprintf("'%s'", parts[1]))
-				}
-			}
-		}
+	if upstream := ConfigFrom(req.Context()).Upstream; upstream.transport != nil {
+		h.Request.UnixSocket = upstream.UnixSocket
+		h.Request.H2C = upstream.H2C
+		// neither is spoken over TLS.
+		h.Request.Insecure = false
 	}
-	h.Args = append(h.Args, fmt.Sprintf("%q", req.URL.String()))
+	h.Request.Header, h.Request.Hidden = policy.split(req.Header)
 
 	h.Index = int(c.count.Add(1) - 1)
+	body := readBody(&req.Body)
+	if len(body) > 0 {
+		c.captureBody(&h, req.Header.Get("Content-Type"), body)
+	}
+	h.Args = c.args(h.Request)
 	return h
 }
 
+// args renders the command of a request, WebSocket sessions with websocat
+// and everything else with curl.
+func (c *Curl) args(req autodemo.Request) []string {
+	if req.WebSocket {
+		return render.Websocat{Shell: c.Shell}.Args(req)
+	}
+	return render.Curl{Shell: c.Shell}.Args(req)
+}
+
 func (c *Curl) updateJar(idx int, u *url.URL, cookies []*http.Cookie) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -128,6 +131,29 @@ func (c *Curl) addJar() int {
 
 func (c *Curl) curlResponseFormat(resp *http.Response) string {
 	var output strings.Builder
+	output.WriteString(c.curlResponseHead(resp))
+
+	// Read response body
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		output.WriteString(fmt.Sprintf("Error reading body: %v\n", err))
+	} else {
+		output.WriteString(c.displayBody(resp.Header.Get("Content-Type"), resp.Header.Get("Content-Encoding"), bodyBytes))
+	}
+
+	// Reset the response body so it can be read again if needed
+	resp.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+
+	return output.String()
+}
+
+func (c *Curl) curlResponseHead(resp *http.Response) string {
+	var output strings.Builder
+	ctx := context.Background()
+	if resp.Request != nil {
+		ctx = resp.Request.Context()
+	}
+	_, policy := c.headerPolicies(ctx)
 
 	// Status line
 	output.WriteString(fmt.Sprintf("\nHTTP/%d.%d %s\n",
@@ -135,14 +161,8 @@ func (c *Curl) curlResponseFormat(resp *http.Response) string {
 
 	// Headers
 	for key, values := range resp.Header {
-		switch key {
-		case "X-Forwarded-For", "User-Agent", "Accept-Encoding", "Content-Length",
-			"Connection", "X-Envoy-Upstream-Service-Time", "Date",
-			"X-Dc-Transaction-Id", "Strict-Transport-Security", "Pragma",
-			"X-Frame-Options", "Cache-Control", "X-Xss-Protection",
-			"X-Content-Type-Options", "Vary", "Expires":
+		if !policy.Visible(key) {
 			continue
-		default:
 		}
 		for _, value := range values {
 			output.WriteString(fmt.Sprintf("%s: %s\n", key, value))
@@ -150,27 +170,10 @@ func (c *Curl) curlResponseFormat(resp *http.Response) string {
 	}
 
 	output.WriteString("\n") // Separate headers from body
-
-	// Read response body
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		output.WriteString(fmt.Sprintf("Error reading body: %v\n", err))
-	} else {
-		if resp.Header.Get("Content-Type") == "application/json" {
-			output.WriteString(maybePrettify(string(bodyBytes)))
-		} else {
-			output.WriteString(string(bodyBytes))
-		}
-	}
-
-	// Reset the response body so it can be read again if needed
-	resp.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
-
 	return output.String()
 }
 
-func (c *Curl) RoundTrip(req *http.Request) (*http.Response, error) {
-	h := c.CurlFromRequest(req)
+func (c *Curl) trackCookies(req *http.Request, resp *http.Response, h *autodemo.History) {
 	var jarIdx int
 	var jarFound bool
 	if len(req.Cookies()) > 0 {
@@ -180,9 +183,6 @@ func (c *Curl) RoundTrip(req *http.Request) (*http.Response, error) {
 			jarFound = true
 		}
 	}
-
-	resp, err := c.Transport.RoundTrip(req)
-
 	if len(resp.Cookies()) > 0 {
 		if !jarFound {
 			jarIdx = c.addJar()
@@ -191,10 +191,144 @@ func (c *Curl) RoundTrip(req *http.Request) (*http.Response, error) {
 		c.updateJar(jarIdx, req.URL, resp.Cookies())
 	}
 	if jarFound {
-		h.Args = append(h.Args, "--cookie-jar", fmt.Sprintf("jar-%d.txt", jarIdx))
+		h.Request.CookieJar = fmt.Sprintf("jar-%d.txt", jarIdx)
+		h.Args = c.args(h.Request)
+	}
+}
+
+func readBody(body *io.ReadCloser) []byte {
+	if *body == nil || *body == http.NoBody {
+		return nil
+	}
+	data, _ := io.ReadAll(*body)
+	(*body).Close()
+	*body = io.NopCloser(bytes.NewReader(data))
+	return data
+}
+
+func (c *Curl) RoundTrip(req *http.Request) (*http.Response, error) {
+	h := c.CurlFromRequest(req)
+	reqBody := readBody(&req.Body)
+
+	if isWebSocketRequest(req) {
+		// compressed frames could not be read back.
+		req.Header.Del("Sec-WebSocket-Extensions")
+	}
+
+	started := time.Now()
+	ctx, trace := newTracer(req.Context(), started)
+	req = req.WithContext(ctx)
+	next := c.Transport
+	if upstream := ConfigFrom(req.Context()).Upstream; upstream.transport != nil {
+		next = upstream.transport
+	}
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		// a client that gave up is not a failure of the upstream.
+		if !errors.Is(req.Context().Err(), context.Canceled) {
+			h.ExecTime = time.Since(started)
+			h.Latency = trace.Latency()
+			h.Failure = curlError(req, err, h.ExecTime)
+			h.Output = h.Failure + "\n"
+			c.notify(req, reqBody, nil, nil, h, started, trace.Timings(time.Now()))
+		}
+		return nil, err
+	}
+
+	c.trackCookies(req, resp, &h)
+	if conn, ok := resp.Body.(io.ReadWriteCloser); ok && isWebSocket(resp) {
+		h.Request.WebSocket = true
+		h.Args = c.args(h.Request)
+		h.ExecTime = time.Since(started)
+		h.Latency = trace.Latency()
+		timings := trace.Timings(time.Now())
+		resp.Body = newWebSocketRecorder(conn, func(messages []autodemo.Message) {
+			for i := range messages {
+				messages[i].Data = c.Truncate.Apply(messages[i].Data)
+			}
+			h.Messages = messages
+			c.notify(req, reqBody, resp, nil, h, started, timings)
+		})
+		return resp, err
+	}
+	sep, split := streamSeparator(resp)
+	if split || resp.ContentLength < 0 {
+		// a body of unknown length is passed on as it arrives, such as long
+		// polls and progress output. Only event streams are recorded chunk
+		// by chunk.
+		head := c.curlResponseHead(resp)
+		h.Output = head
+		h.ExecTime = time.Since(started)
+		h.Latency = trace.Latency()
+		resp.Body = newStreamRecorder(resp.Body, sep, split, func(respBody []byte, chunks []autodemo.Chunk) {
+			finished := time.Now()
+			if split {
+				h.Chunks = chunks
+			} else {
+				h.Output = head + c.displayBody(resp.Header.Get("Content-Type"), resp.Header.Get("Content-Encoding"), respBody)
+				h.ExecTime = finished.Sub(started)
+			}
+			c.notify(req, reqBody, resp, respBody, h, started, trace.Timings(finished))
+		})
+		return resp, err
 	}
 	h.Output = c.curlResponseFormat(resp)
+	finished := time.Now()
+	h.ExecTime = finished.Sub(started)
+	h.Latency = trace.Latency()
+	respBody := readBody(&resp.Body)
+	c.notify(req, reqBody, resp, respBody, h, started, trace.Timings(finished))
+	return resp, err
+}
+
+// notify sends the redacted history and HAR entry of a finished round trip
+// to the listeners unless the proxy filters it out. resp is nil when the
+// request failed.
+func (c *Curl) notify(req *http.Request, reqBody []byte, resp *http.Response, respBody []byte, h autodemo.History, started time.Time, timings har.Timings) {
+	if !ConfigFrom(req.Context()).Filter.Allow(req, resp) {
+		return
+	}
+	if resp != nil {
+		_, policy := c.headerPolicies(req.Context())
+		h.Response = captureResponse(resp, respBody, policy)
+	}
+	spec := ConfigFrom(req.Context()).OpenAPI
+	if spec == nil {
+		spec = c.OpenAPI
+	}
+	h = spec.Label(req.Context(), h)
+	h.Started = started
+	entry := harEntry(req, reqBody, resp, respBody, started, timings)
+	if c.Redactor != nil {
+		c.Redactor.Learn(req.Header, reqBody)
+		for _, artifact := range h.Artifacts {
+			c.Redactor.Learn(nil, artifact.Data)
+		}
+		if resp != nil {
+			c.Redactor.Learn(resp.Header, []byte(h.Response.Body))
+		}
+		h = c.Redactor.History(h)
+		h.Args = c.args(h.Request)
+		entry = c.Redactor.Entry(entry)
+	}
+	entry.Response.Error = h.Failure
 	c.Listener.Notify(h)
+	if c.Archive != nil {
+		c.Archive.NotifyEntry(entry)
+	}
+}
 
-	return resp, err
+// harEntry keeps the decoded response body in the entry, as browsers do,
+// and the size it was sent with as the body size.
+func harEntry(req *http.Request, reqBody []byte, resp *http.Response, respBody []byte, started time.Time, timings har.Timings) har.Entry {
+	if resp == nil {
+		return har.NewEntry(req, reqBody, nil, nil, started, timings)
+	}
+	decoded, err := decodeBody(resp.Header.Get("Content-Encoding"), respBody)
+	if err != nil {
+		decoded = respBody
+	}
+	entry := har.NewEntry(req, reqBody, resp, decoded, started, timings)
+	entry.Response.BodySize = len(respBody)
+	return entry
 }
@@ -0,0 +1,43 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"golang.org/x/net/http2"
+)
+
+// Upstream is how a proxy reaches a server that is not spoken to over TCP
+// with HTTP/1.1 or TLS, such as a daemon on a Unix socket or a service that
+// only speaks h2c.
+type Upstream struct {
+	UnixSocket string
+	H2C        bool
+
+	transport http.RoundTripper
+}
+
+func NewUpstream(unixSocket string, h2c bool) Upstream {
+	var d net.Dialer
+	dial := d.DialContext
+	if unixSocket != "" {
+		dial = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return d.DialContext(ctx, "unix", unixSocket)
+		}
+	}
+	u := Upstream{UnixSocket: unixSocket, H2C: h2c}
+	switch {
+	case h2c:
+		u.transport = &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dial(ctx, network, addr)
+			},
+		}
+	case unixSocket != "":
+		u.transport = &http.Transport{DialContext: dial}
+	}
+	return u
+}
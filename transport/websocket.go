@@ -0,0 +1,213 @@
+package transport
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slcjordan/autodemo"
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+)
+
+// maxMessageSize bounds what is kept of a single message. The rest is still
+// proxied.
+const maxMessageSize = 1 << 20
+
+func isWebSocketRequest(req *http.Request) bool {
+	return strings.EqualFold(req.Header.Get("Upgrade"), "websocket")
+}
+
+func isWebSocket(resp *http.Response) bool {
+	return resp != nil && resp.StatusCode == http.StatusSwitchingProtocols &&
+		strings.EqualFold(resp.Header.Get("Upgrade"), "websocket")
+}
+
+// frameParser reads the messages of one direction of a WebSocket out of
+// the bytes as they are proxied. Only the header of a frame is buffered;
+// the payload is unmasked as it passes and only the first maxMessageSize
+// bytes of a message are kept.
+type frameParser struct {
+	header    []byte // of the next frame, until it is whole
+	inFrame   bool   // the header was read and the payload is passing
+	remaining uint64 // of the payload of the frame
+	offset    uint64 // into the payload of the frame, for the mask
+	mask      [4]byte
+	masked    bool
+	fin       bool
+	data      bool // the frame is part of a message, not a control frame
+
+	message []byte
+	opcode  byte // of the message being reassembled from fragments
+	size    int  // of the message, including what was not kept
+	emit    func(opcode byte, payload []byte, size int)
+}
+
+// headerSize returns the size of the frame header that starts with b, or 0
+// when b is too short to tell.
+func headerSize(b []byte) int {
+	if len(b) < 2 {
+		return 0
+	}
+	n := 2
+	switch b[1] & 0x7f {
+	case 126:
+		n += 2
+	case 127:
+		n += 8
+	}
+	if b[1]&0x80 != 0 {
+		n += 4
+	}
+	return n
+}
+
+func (p *frameParser) feed(data []byte) {
+	for len(data) > 0 {
+		if !p.inFrame {
+			// a header is at most 14 bytes so it is read a byte at a time.
+			p.header = append(p.header, data[0])
+			data = data[1:]
+			if n := headerSize(p.header); n == 0 || len(p.header) < n {
+				continue
+			}
+			p.start(p.header)
+			p.header = p.header[:0]
+		} else {
+			n := min(uint64(len(data)), p.remaining)
+			p.payload(data[:n])
+			data, p.remaining = data[n:], p.remaining-n
+		}
+		if p.remaining == 0 {
+			p.end()
+		}
+	}
+}
+
+// start begins the frame of a whole header.
+func (p *frameParser) start(header []byte) {
+	p.fin = header[0]&0x80 != 0
+	length := uint64(header[1] & 0x7f)
+	i := 2
+	switch length {
+	case 126:
+		length, i = uint64(binary.BigEndian.Uint16(header[2:4])), 4
+	case 127:
+		length, i = binary.BigEndian.Uint64(header[2:10]), 10
+	}
+	p.masked = header[1]&0x80 != 0
+	if p.masked {
+		copy(p.mask[:], header[i:i+4])
+	}
+	p.inFrame, p.remaining, p.offset = true, length, 0
+	switch opcode := header[0] & 0x0f; opcode {
+	case opText, opBinary:
+		p.opcode, p.message, p.size, p.data = opcode, nil, 0, true
+	case opContinuation:
+		p.data = true
+	default:
+		// ping, pong and close frames are not part of the conversation.
+		p.data = false
+	}
+}
+
+func (p *frameParser) payload(b []byte) {
+	if p.data {
+		p.size += len(b)
+		if room := maxMessageSize - len(p.message); room > 0 {
+			kept := len(p.message)
+			p.message = append(p.message, b[:min(room, len(b))]...)
+			if p.masked {
+				for i := kept; i < len(p.message); i++ {
+					p.message[i] ^= p.mask[(p.offset+uint64(i-kept))%4]
+				}
+			}
+		}
+	}
+	p.offset += uint64(len(b))
+}
+
+// end finishes the frame and emits the message it completes.
+func (p *frameParser) end() {
+	p.inFrame = false
+	if p.data && p.fin {
+		p.emit(p.opcode, p.message, p.size)
+		p.message, p.size = nil, 0
+	}
+}
+
+// webSocketRecorder sits between the proxy and the upgraded connection to
+// the upstream. Reads are messages received from the upstream and writes
+// are messages the client sent.
+type webSocketRecorder struct {
+	io.ReadWriteCloser
+	opened time.Time
+
+	mu       sync.Mutex // guards messages
+	messages []autodemo.Message
+	sent     frameParser
+	received frameParser
+
+	once sync.Once
+	done func([]autodemo.Message)
+}
+
+func newWebSocketRecorder(conn io.ReadWriteCloser, done func([]autodemo.Message)) *webSocketRecorder {
+	r := &webSocketRecorder{
+		ReadWriteCloser: conn,
+		opened:          time.Now(),
+		done:            done,
+	}
+	r.sent.emit = r.record(true)
+	r.received.emit = r.record(false)
+	return r
+}
+
+func (r *webSocketRecorder) record(sent bool) func(byte, []byte, int) {
+	return func(opcode byte, payload []byte, size int) {
+		data := string(payload)
+		if opcode == opBinary {
+			data = fmt.Sprintf("[binary message: %s]", byteSize(size))
+		} else if size > len(payload) {
+			data += fmt.Sprintf("[... %s elided ...]", byteSize(size-len(payload)))
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.messages = append(r.messages, autodemo.Message{
+			Offset: time.Since(r.opened),
+			Sent:   sent,
+			Data:   data,
+		})
+	}
+}
+
+func (r *webSocketRecorder) Read(p []byte) (int, error) {
+	n, err := r.ReadWriteCloser.Read(p)
+	r.received.feed(p[:n])
+	return n, err
+}
+
+func (r *webSocketRecorder) Write(p []byte) (int, error) {
+	n, err := r.ReadWriteCloser.Write(p)
+	r.sent.feed(p[:n])
+	return n, err
+}
+
+func (r *webSocketRecorder) Close() error {
+	err := r.ReadWriteCloser.Close()
+	r.once.Do(func() {
+		r.mu.Lock()
+		messages := r.messages
+		r.mu.Unlock()
+		r.done(messages)
+	})
+	return err
+}
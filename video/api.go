@@ -8,20 +8,31 @@ import (
 
 	"github.com/slcjordan/autodemo"
 	"github.com/slcjordan/autodemo/db"
+	"github.com/slcjordan/autodemo/har"
 	"github.com/slcjordan/autodemo/logger"
+	"github.com/slcjordan/autodemo/transport"
 )
 
 type API struct {
-	conn *db.Conn
-	mux  *http.ServeMux
+	conn        *db.Conn
+	mux         *http.ServeMux
+	redactRules []transport.RedactRule
+	truncate    transport.Truncation
 }
 
-func NewAPI(conn *db.Conn) *API {
+// NewAPI redacts and truncates imported archives with redactRules and
+// truncate, as the proxy does with what it records. The rules should have
+// passed transport.ValidateRedactRules; every import learns its own secrets
+// with a redactor of its own.
+func NewAPI(conn *db.Conn, redactRules []transport.RedactRule, truncate transport.Truncation) *API {
 	api := API{
-		conn: conn,
+		conn:        conn,
+		redactRules: redactRules,
+		truncate:    truncate,
 	}
 	mux := http.NewServeMux()
 	mux.HandleFunc("POST /project", api.SaveProject)
+	mux.HandleFunc("POST /project/har", api.ImportProject)
 	mux.HandleFunc("POST /project/{project}/history", api.SaveHistory)
 	api.mux = mux
 	return &api
@@ -66,6 +77,66 @@ func (a *API) SaveProject(w http.ResponseWriter, r *http.Request) {
 	a.conn.MaybeSaveProjectJob(ctx, project)
 }
 
+func (a *API) ImportProject(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var body struct {
+		Project autodemo.Project
+		Archive har.Archive
+	}
+	dec := json.NewDecoder(r.Body)
+	err := dec.Decode(&body)
+	if err != nil {
+		logger.Infof(ctx, "could not decode import: %s", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	project := body.Project
+	dirPath := filepath.Join(project.WorkingDir, project.Name)
+	if _, err := os.Stat(dirPath); err == nil {
+		logger.Infof(ctx, "Directory '%s' exists\n", dirPath)
+		http.Error(w, "project already exists", http.StatusConflict)
+		return
+	}
+	redactor, err := transport.NewRedactor(a.redactRules...)
+	if err != nil {
+		logger.Errorf(ctx, "could not create redactor: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	curl := transport.Curl{
+		Redactor: redactor,
+		Truncate: a.truncate,
+	}
+	var histories []autodemo.History
+	for i, entry := range body.Archive.Log.Entries {
+		history, err := curl.HistoryFromEntry(entry)
+		if err != nil {
+			logger.Infof(ctx, "skipping har entry %d: %s", i, err)
+			continue
+		}
+		histories = append(histories, history)
+	}
+	if len(histories) == 0 {
+		http.Error(w, "no importable har entries", http.StatusBadRequest)
+		return
+	}
+	err = os.MkdirAll(dirPath, 0755)
+	if err != nil {
+		logger.Errorf(ctx, "could not create project directory: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, history := range histories {
+		err = a.conn.MaybeSaveHistoryJob(ctx, project.Name, history)
+		if err != nil {
+			logger.Errorf(ctx, "could not save imported history: %s", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	a.conn.MaybeSaveProjectJob(ctx, project)
+}
+
 func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	a.mux.ServeHTTP(w, r)
 }
@@ -20,13 +20,20 @@ import (
 
 	"github.com/slcjordan/autodemo"
 	"github.com/slcjordan/autodemo/db"
+	"github.com/slcjordan/autodemo/export"
+	"github.com/slcjordan/autodemo/extract"
 	"github.com/slcjordan/autodemo/logger"
+	"github.com/slcjordan/autodemo/openapi"
+	"github.com/slcjordan/autodemo/poll"
+	"github.com/slcjordan/autodemo/render"
+	"github.com/slcjordan/autodemo/shell"
+	"github.com/slcjordan/autodemo/transport"
 )
 
 func ptyList(ctx context.Context) []string {
 	files, err := os.ReadDir("/dev/pts")
 	if err != nil {
-		logger.Errorf(ctx, "could not list pty:", err)
+		logger.Errorf(ctx, "could not list pty: %s", err)
 		return nil
 	}
 
@@ -197,6 +204,12 @@ func (w *Worker) concat(ctx context.Context, project autodemo.Project) error {
 		}
 		io.Copy(md, src)
 		src.Close()
+		alt, err := os.Open(filepath.Join(project.WorkingDir, project.Name, strings.Replace(descs[i], "desc-", "alt-", 1)))
+		if err != nil {
+			continue
+		}
+		io.Copy(md, alt)
+		alt.Close()
 	}
 	md.Close()
 	file.Close()
@@ -305,8 +318,20 @@ func (w *Worker) runHistory(ctx context.Context, project autodemo.Project, histo
 		return err
 	}
 	defer file.Close()
+	renderer, args, err := commandArgs(project, history)
+	if err != nil {
+		return err
+	}
+	err = writeAlternatives(project, history)
+	if err != nil {
+		return err
+	}
+	err = writeArtifacts(project, history)
+	if err != nil {
+		return err
+	}
 	fmt.Fprintf(file, "command %d\n------------\n\n", history.Index)
-	fmt.Fprintf(file, "```bash\n$ ")
+	fmt.Fprintf(file, "```%s\n$ ", renderer.Lang())
 
 	var done chan struct{}
 	ffmpeg := exec.CommandContext(
@@ -343,14 +368,14 @@ func (w *Worker) runHistory(ctx context.Context, project autodemo.Project, histo
 		return err
 	}
 	time.Sleep(500 * time.Millisecond)
-	for i, arg := range history.Args {
+	for i, arg := range args {
 		if i > 0 {
 			w.clicks.Click()
 			pty.Write([]byte(" "))
 			file.Write([]byte(" "))
-			if isFlag(arg) || !isFlag(history.Args[i-1]) {
-				pty.Write([]byte("\\\n  "))
-				file.Write([]byte("\\\n  "))
+			if render.BreakBefore(args, i) {
+				pty.Write([]byte(renderer.Continuation()))
+				file.Write([]byte(renderer.Continuation()))
 			}
 		}
 		w.clicks.Click()
@@ -363,10 +388,23 @@ func (w *Worker) runHistory(ctx context.Context, project autodemo.Project, histo
 	pty.Write([]byte("\n"))
 	file.Write([]byte("\n"))
 	w.clicks.Stop()
-	time.Sleep(history.ExecTime)
+	waitFor(pty, project, replayDelay(project, history.ExecTime))
 	pty.Write([]byte(history.Output))
 	file.Write([]byte(history.Output))
-	fmt.Fprintf(file, "\n```\n\n\n")
+	replayChunks(pty, file, project, history.Chunks)
+	replayMessages(pty, file, project, history.Messages)
+	err = typeExtractions(pty, file, project, history)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(file, "\n```\n\n")
+	if history.ExecTime > 0 {
+		fmt.Fprintf(file, "_%s_\n\n", latencySummary(history))
+	}
+	writePoll(file, history.Poll)
+	writeOperation(file, history.Operation)
+	writeMessageTimeline(file, history.Messages)
+	fmt.Fprintf(file, "\n")
 	pty.Close()
 
 	hitReturn := exec.CommandContext(
@@ -381,11 +419,295 @@ func (w *Worker) runHistory(ctx context.Context, project autodemo.Project, histo
 	return err
 }
 
-func isFlag(arg string) bool {
-	if len(arg) == 0 {
+// typeExtractions types the commands that set the variables later steps
+// read from this step's response. The keyboard clicks of the step have
+// already been mixed so these are typed silently.
+func typeExtractions(pty io.Writer, file io.Writer, project autodemo.Project, history autodemo.History) error {
+	if len(history.Extract) == 0 {
+		return nil
+	}
+	dialect, err := shell.Parse(project.Shell)
+	if err != nil {
+		return err
+	}
+	for _, v := range history.Extract {
+		line := render.Extraction(dialect, v)
+		time.Sleep(350 * time.Millisecond)
+		fmt.Fprintf(pty, "\n$ ")
+		fmt.Fprintf(file, "\n$ ")
+		for _, r := range line {
+			fmt.Fprintf(pty, "%c", r)
+			time.Sleep(30 * time.Millisecond)
+		}
+		fmt.Fprint(file, line)
+		fmt.Fprintf(pty, "\n")
+		fmt.Fprintf(file, "\n")
+	}
+	return nil
+}
+
+// replayChunks writes a streamed response the way it arrived. The gaps
+// between chunks are scaled and capped like the latency of a step.
+func replayChunks(pty io.Writer, file io.Writer, project autodemo.Project, chunks []autodemo.Chunk) {
+	var last time.Duration
+	for _, chunk := range chunks {
+		time.Sleep(replayDelay(project, chunk.Offset-last))
+		last = chunk.Offset
+		pty.Write([]byte(chunk.Data))
+		file.Write([]byte(chunk.Data))
+	}
+}
+
+// maxTypedMessage is the longest sent message that is typed. Longer ones
+// are pasted at once, as they would be.
+const maxTypedMessage = 120
+
+// replayMessages plays a WebSocket session back. Sent messages are typed
+// silently like extractions and received ones are written when they came.
+func replayMessages(pty io.Writer, file io.Writer, project autodemo.Project, messages []autodemo.Message) {
+	var last time.Duration
+	for _, message := range messages {
+		time.Sleep(replayDelay(project, message.Offset-last))
+		last = message.Offset
+		if !message.Sent || len(message.Data) > maxTypedMessage {
+			fmt.Fprintf(pty, "%s\n", message.Data)
+			fmt.Fprintf(file, "%s\n", message.Data)
+			continue
+		}
+		for _, r := range message.Data {
+			fmt.Fprintf(pty, "%c", r)
+			time.Sleep(30 * time.Millisecond)
+		}
+		fmt.Fprintf(pty, "\n")
+		fmt.Fprintf(file, "%s\n", message.Data)
+	}
+}
+
+// writeMessageTimeline lists who sent each WebSocket message and when since
+// the terminal does not show it.
+func writeMessageTimeline(file io.Writer, messages []autodemo.Message) {
+	for _, message := range messages {
+		direction := "received"
+		if message.Sent {
+			direction = "sent"
+		}
+		fmt.Fprintf(file, "- +%s %s `%s`\n", message.Offset.Round(time.Millisecond), direction, strings.ReplaceAll(message.Data, "`", "'"))
+	}
+	if len(messages) > 0 {
+		fmt.Fprintf(file, "\n")
+	}
+}
+
+// replayDelay scales and caps the recorded latency of a step.
+func replayDelay(project autodemo.Project, execTime time.Duration) time.Duration {
+	scale := project.LatencyScale
+	if scale <= 0 {
+		scale = 1
+	}
+	delay := time.Duration(float64(execTime) * scale)
+	if project.MaxLatency > 0 && delay > project.MaxLatency {
+		delay = project.MaxLatency
+	}
+	return delay
+}
+
+// slow calls get a spinner when the project asks for one.
+const spinnerAfter = time.Second
+
+var spinnerFrames = []string{"|", "/", "-", "\\"}
+
+func waitFor(pty io.Writer, project autodemo.Project, delay time.Duration) {
+	if !project.Spinner || delay < spinnerAfter {
+		time.Sleep(delay)
+		return
+	}
+	started := time.Now()
+	for i := 0; time.Since(started) < delay; i++ {
+		fmt.Fprintf(pty, "\r%s %.1fs", spinnerFrames[i%len(spinnerFrames)], time.Since(started).Seconds())
+		time.Sleep(min(100*time.Millisecond, delay-time.Since(started)))
+	}
+	// carriage return and erase the spinner line.
+	fmt.Fprint(pty, "\r\x1b[K")
+}
+
+func latencySummary(history autodemo.History) string {
+	summary := fmt.Sprintf("took %s", history.ExecTime.Round(time.Millisecond))
+	var phases []string
+	for _, phase := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"dns", history.Latency.DNS},
+		{"connect", history.Latency.Connect},
+		{"tls", history.Latency.TLS},
+		{"first byte", history.Latency.FirstByte},
+	} {
+		if phase.d > 0 {
+			phases = append(phases, fmt.Sprintf("%s %s", phase.name, phase.d.Round(time.Millisecond)))
+		}
+	}
+	if len(phases) > 0 {
+		summary += " (" + strings.Join(phases, ", ") + ")"
+	}
+	return summary
+}
+
+// writePoll tells the narration that one step stands for many requests.
+func writePoll(file io.Writer, p *autodemo.Poll) {
+	if p == nil {
+		return
+	}
+	fmt.Fprintf(file, "_polled %d times", p.Count)
+	if p.Interval > 0 {
+		fmt.Fprintf(file, " every %s", p.Interval.Round(100*time.Millisecond))
+	}
+	if p.Until != "" {
+		fmt.Fprintf(file, " until `%s`", p.Until)
+	} else {
+		fmt.Fprintf(file, " until the response changed")
+	}
+	fmt.Fprintf(file, "_\n\n")
+}
+
+// writeOperation labels a step with its OpenAPI operation and flags where
+// the traffic does not follow the document.
+func writeOperation(file io.Writer, op autodemo.Operation) {
+	if op.ID != "" {
+		fmt.Fprintf(file, "operation `%s`", op.ID)
+		if op.Summary != "" {
+			fmt.Fprintf(file, ": %s", op.Summary)
+		}
+		fmt.Fprintf(file, "\n\n")
+	}
+	for _, problem := range op.Problems {
+		fmt.Fprintf(file, "- **does not match the API spec:** %s\n", problem)
+	}
+	if len(op.Problems) > 0 {
+		fmt.Fprintf(file, "\n")
+	}
+}
+
+// operationNote tells the narration what the API calls a step.
+func operationNote(op autodemo.Operation) string {
+	if op.ID == "" {
+		return ""
+	}
+	note := fmt.Sprintf("API operation %s", op.ID)
+	if op.Summary != "" {
+		note += ": " + op.Summary
+	}
+	if op.Description != "" {
+		note += "\n" + op.Description
+	}
+	return note + "\n\n"
+}
+
+func commandArgs(project autodemo.Project, history autodemo.History) (render.Renderer, []string, error) {
+	dialect, err := shell.Parse(project.Shell)
+	if err != nil {
+		return nil, nil, err
+	}
+	renderer, ok := render.Lookup(project.Renderer, dialect)
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown renderer: %q", project.Renderer)
+	}
+	if history.Request.Method == "" {
+		return renderer, history.Args, nil
+	}
+	if history.Request.WebSocket {
+		renderer = render.Websocat{Shell: dialect}
+		return renderer, renderer.Args(history.Request), nil
+	}
+	if history.Failure != "" || !render.Expresses(renderer, history.Request) {
+		// the output is curl's error message, or the request went over
+		// something only curl speaks.
+		renderer = render.Curl{Shell: dialect}
+		return renderer, renderer.Args(history.Request), nil
+	}
+	args := renderer.Args(history.Request)
+	if history.SaveAs != "" {
+		args, _ = render.SaveBody(renderer, dialect, args, history.SaveAs)
+	}
+	if history.Poll != nil {
+		args, _ = render.Poll(renderer, dialect, args, *history.Poll)
+	}
+	return renderer, args, nil
+}
+
+func dropFailures(histories []autodemo.History) []autodemo.History {
+	var result []autodemo.History
+	for _, history := range histories {
+		if history.Failure == "" {
+			result = append(result, history)
+		}
+	}
+	return result
+}
+
+// renumber closes the gaps that filtered requests leave in the indexes
+// because clips and narration are matched up by position.
+func renumber(histories []autodemo.History) []autodemo.History {
+	for i := range histories {
+		histories[i].Index = i
+	}
+	return histories
+}
+
+// savesBody reports whether the commands of a project can save responses
+// for variables to be extracted from.
+func savesBody(project autodemo.Project) bool {
+	dialect, err := shell.Parse(project.Shell)
+	if err != nil {
+		return false
+	}
+	renderer, ok := render.Lookup(project.Renderer, dialect)
+	if !ok {
 		return false
 	}
-	return arg[0] == '-'
+	_, ok = render.SaveBody(renderer, dialect, nil, "")
+	return ok
+}
+
+func writeAlternatives(project autodemo.Project, history autodemo.History) error {
+	if len(project.Languages) == 0 || history.Request.Method == "" {
+		return nil
+	}
+	file, err := os.OpenFile(
+		filepath.Join(project.WorkingDir, project.Name, fmt.Sprintf("alt-%03d.md", history.Index)),
+		os.O_TRUNC|os.O_CREATE|os.O_WRONLY,
+		0644,
+	)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	dialect, err := shell.Parse(project.Shell)
+	if err != nil {
+		return err
+	}
+	for _, name := range project.Languages {
+		renderer, ok := render.Lookup(name, dialect)
+		if !ok {
+			return fmt.Errorf("unknown renderer: %q", name)
+		}
+		if !render.Expresses(renderer, history.Request) {
+			continue
+		}
+		fmt.Fprintf(file, "_%s_\n\n```%s\n%s\n```\n\n", renderer.Name(), renderer.Lang(), strings.TrimSuffix(render.Snippet(renderer, history.Request), "\n"))
+	}
+	return nil
+}
+
+// writeArtifacts saves uploaded files and binary bodies next to the
+// recording so that the typed command can refer to them.
+func writeArtifacts(project autodemo.Project, history autodemo.History) error {
+	for _, artifact := range history.Artifacts {
+		err := os.WriteFile(filepath.Join(project.WorkingDir, project.Name, filepath.Base(artifact.Name)), artifact.Data, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (w *Worker) narrateClip(ctx context.Context, filename string, text string) error {
@@ -464,6 +786,14 @@ func (w *Worker) askChatGPT(ctx context.Context, project autodemo.Project) ([]st
 		}
 		filenames = append(filenames, filepath.Join(project.WorkingDir, project.Name, elem.Name()))
 	}
+	histories, err := w.db.ListHistories(ctx, project.Name)
+	if err != nil {
+		return nil, err
+	}
+	operations := make(map[string]autodemo.Operation)
+	for _, history := range histories {
+		operations[fmt.Sprintf("desc-%03d.md", history.Index)] = history.Operation
+	}
 	prompt := bytes.NewBuffer([]byte(project.Desc + "\n\nTest Plan\n=========\n\n\n"))
 	filenames = sort.StringSlice(filenames)
 	for _, curr := range filenames {
@@ -473,10 +803,11 @@ func (w *Worker) askChatGPT(ctx context.Context, project autodemo.Project) ([]st
 		}
 		io.Copy(prompt, f)
 		f.Close()
+		prompt.WriteString(operationNote(operations[filepath.Base(curr)]))
 	}
 	prompt.Write([]byte(fmt.Sprintf(`
 
-This is a test plan for a feature in the DigiCert One API. I need a script to narrate a training video for the QA engineers. The script should write all acronyms uppercase as it will be narrated by elevenlabs. Each curl request has its own clip. Please explain how each step fits into the overall test plan. Format the output as JSON with a clips array, where each clip has a name and narration field. The clips array must be length %d. Respond only with a valid JSON object. No text before or after.
+This is a test plan for a feature in the DigiCert One API. I need a script to narrate a training video for the QA engineers. The script should write all acronyms uppercase as it will be narrated by elevenlabs. Each curl request has its own clip. Please explain how each step fits into the overall test plan. When a step names its API operation, use that operation's vocabulary. A step that polled stands for all of its requests, narrate it as waiting for the result. Format the output as JSON with a clips array, where each clip has a name and narration field. The clips array must be length %d. Respond only with a valid JSON object. No text before or after.
 `, len(filenames))))
 	body := strings.NewReader(fmt.Sprintf(`
 {
@@ -583,6 +914,48 @@ func (w *Worker) runProject(ctx context.Context, status string, project autodemo
 				logger.Errorf(ctx, "could not hit return: %s", err)
 			}
 		}()
+		var spec *openapi.Spec
+		if project.OpenAPI != "" {
+			spec, err = openapi.Parse(ctx, []byte(project.OpenAPI))
+			if err != nil {
+				logger.Errorf(ctx, "could not parse openapi document of project %q: %s", project.Name, err)
+				return err
+			}
+		}
+		err = w.db.RewriteHistories(ctx, project.Name, func(histories []autodemo.History) []autodemo.History {
+			if project.DropFailures {
+				histories = dropFailures(histories)
+			}
+			histories = poll.Collapse(histories)
+			histories = renumber(histories)
+			if len(project.HideHeaders) > 0 {
+				for i := range histories {
+					histories[i] = transport.HideHeaders(histories[i], project.HideHeaders)
+				}
+			}
+			if spec != nil {
+				for i := range histories {
+					histories[i] = spec.Label(ctx, histories[i])
+				}
+			}
+			if savesBody(project) {
+				histories = extract.Variables(histories)
+			}
+			return histories
+		})
+		if err != nil {
+			logger.Errorf(ctx, "could not rewrite histories for project %q: %s", project.Name, err)
+			return err
+		}
+		histories, err := w.db.ListHistories(ctx, project.Name)
+		if err != nil {
+			logger.Errorf(ctx, "could not list histories for project %q: %s", project.Name, err)
+			return err
+		}
+		err = export.Write(filepath.Join(project.WorkingDir, project.Name), project.Name, histories)
+		if err != nil {
+			logger.Errorf(ctx, "could not export project %q: %s", project.Name, err)
+		}
 		for {
 			err := w.db.DoNextHistoryJob(ctx, project, w.runHistory)
 			if err != nil {